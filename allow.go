@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// parseCIDRs returns the networks of --web.allowed-cidrs, whose values are
+// comma-separated. A single address is a network of one host.
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, value := range values {
+		for _, cidr := range strings.Split(value, ",") {
+			cidr = strings.TrimSpace(cidr)
+
+			if len(cidr) == 0 {
+				continue
+			}
+
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 8 * net.IPv6len
+
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+
+			_, network, err := net.ParseCIDR(cidr)
+
+			if err != nil {
+				return nil, fmt.Errorf("allowed CIDR %q: invalid network", cidr)
+			}
+
+			networks = append(networks, network)
+		}
+	}
+
+	return networks, nil
+}
+
+// allowed returns whether the client of r is in networks, or true if
+// networks is empty. The address of the connection is used, X-Forwarded-For
+// being up to the client. Clients of unix sockets are allowed by the
+// permissions of the socket.
+func allowed(networks []*net.IPNet, r *http.Request) bool {
+	if len(networks) == 0 {
+		return true
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowCIDRs serves the requests of clients in networks with next, and
+// rejects the others with 403.
+func allowCIDRs(networks []*net.IPNet, next http.Handler, logger log.Logger) http.Handler {
+	if len(networks) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(networks, r) {
+			level.Debug(logger).Log("msg", "Rejected request from a client outside --web.allowed-cidrs", "client", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxCDRSize is the maximum size of the body of a CDR request.
+const maxCDRSize = 4 << 20
+
+// otherLabel is the label value of the gateways over the limit of distinct
+// gateways, and of the hangup causes unknown to FreeSWITCH.
+const otherLabel = "other"
+
+// hangupCauses are the hangup causes of FreeSWITCH.
+var hangupCauses = map[string]bool{
+	"NONE":                           true,
+	"UNALLOCATED_NUMBER":             true,
+	"NO_ROUTE_TRANSIT_NET":           true,
+	"NO_ROUTE_DESTINATION":           true,
+	"CHANNEL_UNACCEPTABLE":           true,
+	"CALL_AWARDED_DELIVERED":         true,
+	"NORMAL_CLEARING":                true,
+	"USER_BUSY":                      true,
+	"NO_USER_RESPONSE":               true,
+	"NO_ANSWER":                      true,
+	"SUBSCRIBER_ABSENT":              true,
+	"CALL_REJECTED":                  true,
+	"NUMBER_CHANGED":                 true,
+	"REDIRECTION_TO_NEW_DESTINATION": true,
+	"EXCHANGE_ROUTING_ERROR":         true,
+	"DESTINATION_OUT_OF_ORDER":       true,
+	"INVALID_NUMBER_FORMAT":          true,
+	"FACILITY_REJECTED":              true,
+	"RESPONSE_TO_STATUS_ENQUIRY":     true,
+	"NORMAL_UNSPECIFIED":             true,
+	"NORMAL_CIRCUIT_CONGESTION":      true,
+	"NETWORK_OUT_OF_ORDER":           true,
+	"NORMAL_TEMPORARY_FAILURE":       true,
+	"SWITCH_CONGESTION":              true,
+	"ACCESS_INFO_DISCARDED":          true,
+	"REQUESTED_CHAN_UNAVAIL":         true,
+	"PRE_EMPTED":                     true,
+	"FACILITY_NOT_SUBSCRIBED":        true,
+	"OUTGOING_CALL_BARRED":           true,
+	"INCOMING_CALL_BARRED":           true,
+	"BEARERCAPABILITY_NOTAUTH":       true,
+	"BEARERCAPABILITY_NOTAVAIL":      true,
+	"SERVICE_UNAVAILABLE":            true,
+	"BEARERCAPABILITY_NOTIMPL":       true,
+	"CHAN_NOT_IMPLEMENTED":           true,
+	"FACILITY_NOT_IMPLEMENTED":       true,
+	"SERVICE_NOT_IMPLEMENTED":        true,
+	"INVALID_CALL_REFERENCE":         true,
+	"INCOMPATIBLE_DESTINATION":       true,
+	"INVALID_MSG_UNSPECIFIED":        true,
+	"MANDATORY_IE_MISSING":           true,
+	"MESSAGE_TYPE_NONEXIST":          true,
+	"WRONG_MESSAGE":                  true,
+	"IE_NONEXIST":                    true,
+	"INVALID_IE_CONTENTS":            true,
+	"WRONG_CALL_STATE":               true,
+	"RECOVERY_ON_TIMER_EXPIRE":       true,
+	"MANDATORY_IE_LENGTH_ERROR":      true,
+	"PROTOCOL_ERROR":                 true,
+	"INTERWORKING":                   true,
+	"SUCCESS":                        true,
+	"ORIGINATOR_CANCEL":              true,
+	"CRASH":                          true,
+	"SYSTEM_SHUTDOWN":                true,
+	"LOSE_RACE":                      true,
+	"MANAGER_REQUEST":                true,
+	"BLIND_TRANSFER":                 true,
+	"ATTENDED_TRANSFER":              true,
+	"ALLOTTED_TIMEOUT":               true,
+	"USER_CHALLENGE":                 true,
+	"MEDIA_TIMEOUT":                  true,
+	"PICKED_OFF":                     true,
+	"USER_NOT_REGISTERED":            true,
+	"PROGRESS_TIMEOUT":               true,
+	"INVALID_GATEWAY":                true,
+	"GATEWAY_DOWN":                   true,
+	"INVALID_URL":                    true,
+	"INVALID_PROFILE":                true,
+	"NO_PICKUP":                      true,
+	"SRTP_READ_ERROR":                true,
+	"BOWOUT":                         true,
+	"BUSY_EVERYWHERE":                true,
+	"DECLINE":                        true,
+	"DOES_NOT_EXIST_ANYWHERE":        true,
+	"NOT_ACCEPTABLE":                 true,
+	"UNWANTED":                       true,
+	"NO_IDENTITY":                    true,
+	"BAD_IDENTITY_INFO":              true,
+	"UNSUPPORTED_CERTIFICATE":        true,
+	"INVALID_IDENTITY":               true,
+	"STALE_DATE":                     true,
+	"REJECT_ALL":                     true,
+}
+
+// CDRHandler receives call detail records posted by mod_json_cdr and turns
+// them into metrics. It implements both http.Handler and prometheus.Collector.
+// As CDRs are posted by any client allowed to reach the web endpoints, their
+// hangup causes are restricted to those of FreeSWITCH, and the number of
+// distinct gateways is limited.
+type CDRHandler struct {
+	logger log.Logger
+
+	maxGateways int
+	mutex       sync.Mutex
+	gateways    map[string]bool
+
+	records  *prometheus.CounterVec
+	billsec  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	invalid  prometheus.Counter
+}
+
+// CDR is the part of a mod_json_cdr record used by the exporter.
+type CDR struct {
+	Variables struct {
+		Billsec     string `json:"billsec"`
+		Duration    string `json:"duration"`
+		HangupCause string `json:"hangup_cause"`
+		Gateway     string `json:"sip_gateway_name"`
+	} `json:"variables"`
+}
+
+// NewCDRHandler returns a new CDRHandler, exporting up to maxGateways
+// distinct gateways (unlimited if 0).
+func NewCDRHandler(maxGateways int, logger log.Logger) *CDRHandler {
+	return &CDRHandler{
+		logger:      logger,
+		maxGateways: maxGateways,
+		gateways:    make(map[string]bool),
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Name:      "cdr_total",
+			Help:      "Number of CDRs received, by gateway and hangup cause.",
+		}, []string{"gateway", "hangup_cause"}),
+		billsec: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Name:      "cdr_billsec_seconds_total",
+			Help:      "Billed seconds of the CDRs received, by gateway.",
+		}, []string{"gateway"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: collector.Namespace,
+			Name:      "cdr_duration_seconds",
+			Help:      "Duration of the calls of the CDRs received, by gateway.",
+			Buckets:   collector.DurationBuckets,
+		}, []string{"gateway"}),
+		invalid: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Name:      "cdr_invalid_total",
+			Help:      "Number of CDRs received that could not be parsed.",
+		}),
+	}
+}
+
+// ServeHTTP implements http.Handler. mod_json_cdr posts the record either as
+// the raw JSON body, or URL-encoded in a "cdr" form field.
+func (h *CDRHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCDRSize)
+
+	var body []byte
+	var err error
+
+	// e.g. application/x-www-form-urlencoded; charset=utf-8
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err = r.ParseForm(); err == nil {
+			body = []byte(r.PostForm.Get("cdr"))
+		}
+	} else {
+		body, err = io.ReadAll(r.Body)
+	}
+
+	if err != nil {
+		http.Error(w, "cannot read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cdr CDR
+
+	if err = json.Unmarshal(body, &cdr); err != nil {
+		h.invalid.Inc()
+		level.Error(h.logger).Log("msg", "Cannot read CDR", "err", err)
+		http.Error(w, "invalid CDR", http.StatusBadRequest)
+		return
+	}
+
+	h.record(&cdr)
+}
+
+func (h *CDRHandler) record(cdr *CDR) {
+	v := &cdr.Variables
+	gateway, cause := h.gateway(v.Gateway), v.HangupCause
+
+	if len(cause) != 0 && !hangupCauses[cause] {
+		cause = otherLabel
+	}
+
+	h.records.WithLabelValues(gateway, cause).Inc()
+
+	if billsec, err := strconv.ParseFloat(v.Billsec, 64); err == nil {
+		h.billsec.WithLabelValues(gateway).Add(billsec)
+	}
+
+	if duration, err := strconv.ParseFloat(v.Duration, 64); err == nil {
+		h.duration.WithLabelValues(gateway).Observe(duration)
+	}
+}
+
+// gateway returns the gateway label of the CDRs of name, other once
+// maxGateways distinct gateways have been seen.
+func (h *CDRHandler) gateway(name string) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.gateways[name] || h.maxGateways == 0 {
+		return name
+	}
+
+	if len(h.gateways) >= h.maxGateways {
+		return otherLabel
+	}
+
+	h.gateways[name] = true
+
+	return name
+}
+
+// Describe implements prometheus.Collector.
+func (h *CDRHandler) Describe(ch chan<- *prometheus.Desc) {
+	h.records.Describe(ch)
+	h.billsec.Describe(ch)
+	h.duration.Describe(ch)
+	h.invalid.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (h *CDRHandler) Collect(ch chan<- prometheus.Metric) {
+	h.records.Collect(ch)
+	h.billsec.Collect(ch)
+	h.duration.Collect(ch)
+	h.invalid.Collect(ch)
+}
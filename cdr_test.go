@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postCDR(h http.Handler, contentType, body string) int {
+	r := httptest.NewRequest(http.MethodPost, "/cdr", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func cdr(gateway, cause string) string {
+	return `{"variables":{"billsec":"30","duration":"35","hangup_cause":"` + cause + `","sip_gateway_name":"` + gateway + `"}}`
+}
+
+func TestCDRLabels(t *testing.T) {
+	h := NewCDRHandler(2, log.NewNopLogger())
+
+	for _, body := range []string{
+		cdr("carrier1", "NORMAL_CLEARING"),
+		cdr("carrier1", "NOT_A_CAUSE"),
+		cdr("carrier2", "USER_BUSY"),
+		cdr("carrier3", "NORMAL_CLEARING"),
+		cdr("carrier1", ""),
+	} {
+		if code := postCDR(h, "application/json", body); code != http.StatusOK {
+			t.Fatalf("got %d, want 200", code)
+		}
+	}
+
+	form := url.Values{"cdr": {cdr("carrier4", "NO_ANSWER")}}.Encode()
+
+	if code := postCDR(h, "application/x-www-form-urlencoded", form); code != http.StatusOK {
+		t.Fatalf("form: got %d, want 200", code)
+	}
+
+	want := `
+# HELP freeswitch_cdr_total Number of CDRs received, by gateway and hangup cause.
+# TYPE freeswitch_cdr_total counter
+freeswitch_cdr_total{gateway="carrier1",hangup_cause=""} 1
+freeswitch_cdr_total{gateway="carrier1",hangup_cause="NORMAL_CLEARING"} 1
+freeswitch_cdr_total{gateway="carrier1",hangup_cause="other"} 1
+freeswitch_cdr_total{gateway="carrier2",hangup_cause="USER_BUSY"} 1
+freeswitch_cdr_total{gateway="other",hangup_cause="NORMAL_CLEARING"} 1
+freeswitch_cdr_total{gateway="other",hangup_cause="NO_ANSWER"} 1
+`
+
+	if err := testutil.CollectAndCompare(h, strings.NewReader(want), "freeswitch_cdr_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCDRSizeLimit(t *testing.T) {
+	h := NewCDRHandler(0, log.NewNopLogger())
+	large := `{"variables":{"hangup_cause":"NORMAL_CLEARING"},"padding":"` + strings.Repeat("x", maxCDRSize) + `"}`
+
+	for _, contentType := range []string{"application/json", "application/x-www-form-urlencoded"} {
+		body := large
+
+		if contentType == "application/x-www-form-urlencoded" {
+			body = url.Values{"cdr": {large}}.Encode()
+		}
+
+		if code := postCDR(h, contentType, body); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", contentType, code)
+		}
+	}
+
+	if count := testutil.CollectAndCount(h, "freeswitch_cdr_total"); count != 0 {
+		t.Errorf("got %d series, want none", count)
+	}
+}
+
+func TestCDRFormCharset(t *testing.T) {
+	h := NewCDRHandler(0, log.NewNopLogger())
+	form := url.Values{"cdr": {cdr("carrier1", "NORMAL_CLEARING")}}.Encode()
+
+	if code := postCDR(h, "application/x-www-form-urlencoded; charset=utf-8", form); code != http.StatusOK {
+		t.Fatalf("got %d, want 200", code)
+	}
+
+	if count := testutil.CollectAndCount(h, "freeswitch_cdr_total"); count != 1 {
+		t.Errorf("got %d series, want 1", count)
+	}
+}
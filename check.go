@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+)
+
+// checkConfig checks the flags and the configuration file, which is already
+// parsed and validated, without connecting to FreeSWITCH. It returns every
+// error found, naming the flag or the part of the configuration at fault.
+func checkConfig(f *flags, config *Config) []error {
+	var errs []error
+
+	options, _, err := newOptions(f, config, log.NewNopLogger())
+
+	if err != nil {
+		return append(errs, err)
+	}
+
+	if _, err := constLabels(*f.constLabels); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := validateNamespace(*f.namespace); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseCIDRs(*f.allowedCIDRs); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := newMetricFilter(*f.include, *f.exclude); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(*f.tracingURL) != 0 {
+		if _, err := parseTracing(*f.tracingURL, *f.tracingRatio); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check := func(what, uri string, options collector.Options) {
+		if err := validateURI(uri); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", what, uri, err))
+		} else if err := collector.Validate(uri, options); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", what, uri, err))
+		}
+	}
+
+	for _, value := range *f.scrapeURIs {
+		for _, uri := range strings.Split(value, ",") {
+			check("scrape URI", strings.TrimSpace(uri), options)
+		}
+	}
+
+	// the SRV record is not looked up, as it may only resolve where the
+	// exporter runs
+	if len(*f.targetsFile) != 0 {
+		d := &discovery{file: *f.targetsFile}
+		uris, err := d.lookup()
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, uri := range uris {
+			check("targets file, target", uri, options)
+		}
+	}
+
+	// targets are scraped with the options of the probe, without events
+	probe := collector.Options{
+		Collectors:        options.Collectors,
+		Commands:          options.Commands,
+		CacheTTLs:         options.CacheTTLs,
+		Role:              options.Role,
+		FallbackPasswords: options.FallbackPasswords,
+		Proxy:             options.Proxy,
+		SSHKeyFile:        options.SSHKeyFile,
+		SSHKnownHostsFile: options.SSHKnownHostsFile,
+	}
+
+	for _, target := range config.Targets {
+		probe := probe
+
+		if len(target.Password) != 0 {
+			probe.FallbackPasswords = target.Password[1:]
+		}
+
+		if len(target.Proxy) != 0 {
+			probe.Proxy = target.Proxy
+		}
+
+		check("target", target.URI, probe)
+	}
+
+	return errs
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// clusterSums are the aggregates of --metrics.cluster summing the samples of
+// a metric of every instance. Calls are summed from the unlabeled count of
+// each instance, which does not depend on the profile labels.
+var clusterSums = []struct{ name, metric, help string }{
+	{"current_calls", "current_calls_all", "Number of calls active on all the instances, each counted once per instance"},
+	{"current_sessions", "current_sessions", "Number of sessions active on all the instances"},
+	{"max_sessions", "max_sessions", "Max sessions allowed on all the instances"},
+	{"current_sps", "current_sps", "Number of sessions per second on all the instances"},
+	{"instances_up", "up", "Number of instances whose last scrape was successful"},
+}
+
+// gatewayDownStates are the registration states of the gateways counted by
+// freeswitch_cluster_gateways_down.
+var gatewayDownStates = map[string]bool{
+	"FAILED":    true,
+	"FAIL_WAIT": true,
+	"EXPIRED":   true,
+	"DOWN":      true,
+	"TIMEOUT":   true,
+}
+
+// withCluster returns a gatherer adding the aggregates of the metrics of all
+// the instances of g to them, labeled with cluster and labels, or g if cluster
+// is empty. Only the metrics in the freeswitch namespace are aggregated.
+func withCluster(g prometheus.Gatherer, cluster string, labels prometheus.Labels) prometheus.Gatherer {
+	if len(cluster) == 0 {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+
+		values := make(map[string]float64)
+		instances, gatewaysDown := 0.0, 0.0
+
+		for _, family := range families {
+			switch family.GetName() {
+			case collector.Namespace + "_up":
+				instances += float64(len(family.Metric))
+			case collector.Namespace + "_gateway_state":
+				for _, m := range family.Metric {
+					if m.GetGauge().GetValue() == 1 && gatewayDownStates[labelValue(m, "state")] {
+						gatewaysDown++
+					}
+				}
+			}
+
+			for _, sum := range clusterSums {
+				if family.GetName() != collector.Namespace+"_"+sum.metric {
+					continue
+				}
+
+				for _, m := range family.Metric {
+					values[sum.name] += m.GetGauge().GetValue()
+				}
+			}
+		}
+
+		for _, sum := range clusterSums {
+			families = append(families, newGaugeFamily("cluster_"+sum.name, sum.help, cluster, labels, values[sum.name]))
+		}
+
+		families = append(families,
+			newGaugeFamily("cluster_instances", "Number of instances scraped", cluster, labels, instances),
+			newGaugeFamily("cluster_gateways_down", "Number of gateways failing to register on all the instances", cluster, labels, gatewaysDown),
+		)
+
+		sort.Slice(families, func(i, j int) bool {
+			return families[i].GetName() < families[j].GetName()
+		})
+
+		return families, err
+	})
+}
+
+// newGaugeFamily returns the family of the gauge named name in the freeswitch
+// namespace, of a single sample labeled with cluster and labels.
+func newGaugeFamily(name, help, cluster string, labels prometheus.Labels, value float64) *dto.MetricFamily {
+	name = collector.Namespace + "_" + name
+	gauge := dto.MetricType_GAUGE
+	pairs := []*dto.LabelPair{newLabelPair("cluster", cluster)}
+
+	for label, labelValue := range labels {
+		pairs = append(pairs, newLabelPair(label, labelValue))
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+
+	return &dto.MetricFamily{
+		Name:   &name,
+		Help:   &help,
+		Type:   &gauge,
+		Metric: []*dto.Metric{{Label: pairs, Gauge: &dto.Gauge{Value: &value}}},
+	}
+}
+
+func newLabelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// labelValue returns the value of the label name of m, empty if it has none.
+func labelValue(m *dto.Metric, name string) string {
+	for _, pair := range m.Label {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+
+	return ""
+}
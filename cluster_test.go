@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestClusterCurrentCalls(t *testing.T) {
+	byProfile := prometheus.NewDesc("freeswitch_current_calls", "Number of calls active", []string{"profile"}, nil)
+	all := prometheus.NewDesc("freeswitch_current_calls_all", "Number of calls active, each counted once whatever its sofia profiles", []string{"fs_instance"}, nil)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(constCollector{
+		// the two calls of edge1, both bridged between its two profiles
+		prometheus.MustNewConstMetric(byProfile, prometheus.GaugeValue, 2, "internal"),
+		prometheus.MustNewConstMetric(byProfile, prometheus.GaugeValue, 2, "external"),
+		prometheus.MustNewConstMetric(all, prometheus.GaugeValue, 2, "edge1"),
+		prometheus.MustNewConstMetric(all, prometheus.GaugeValue, 3, "edge2"),
+	})
+
+	families, err := withCluster(registry, "par1", prometheus.Labels{"dc": "par"}).Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "freeswitch_cluster_current_calls" {
+			continue
+		}
+
+		m := family.Metric[0]
+
+		if value := m.GetGauge().GetValue(); value != 5 {
+			t.Errorf("got %v calls, want 5", value)
+		}
+
+		if labelValue(m, "cluster") != "par1" || labelValue(m, "dc") != "par" {
+			t.Errorf("got labels %v, want cluster par1 and dc par", m.Label)
+		}
+
+		return
+	}
+
+	t.Error("freeswitch_cluster_current_calls missing")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the configuration file.
+//
+// Web, FreeSWITCH, Collector and Metrics hold the values of the web.*,
+// freeswitch.*, collector.* and metrics.* flags, keyed by flag name without
+// prefix and with underscores instead of dashes (e.g. scrape_uri for
+// --freeswitch.scrape-uri).
+type Config struct {
+	Web        map[string]interface{} `yaml:"web"`
+	FreeSWITCH map[string]interface{} `yaml:"freeswitch"`
+	Collector  map[string]interface{} `yaml:"collector"`
+	Metrics    map[string]interface{} `yaml:"metrics"`
+
+	ChannelVariables  []collector.ChannelVariable `yaml:"channel_variables"`
+	Commands          []collector.CommandMetric   `yaml:"commands"`
+	EmergencyPatterns []string                    `yaml:"emergency_patterns"`
+	TLSCertificates   map[string]string           `yaml:"tls_certificates"`
+	Targets           []Target                    `yaml:"targets"`
+	AuthModules       map[string]AuthModule       `yaml:"auth_modules"`
+	RemoteWrite       *RemoteWriteConfig          `yaml:"remote_write"`
+	Role              *collector.RoleDetection    `yaml:"role"`
+	MetricRenames     []MetricRename              `yaml:"metric_renames"`
+}
+
+// Target is a FreeSWITCH instance scraped through the probe endpoint. Its
+// passwords are read from PasswordFile if set, the next ones being fallback
+// passwords, and User is the event socket user to authenticate as, if any.
+// Proxy overrides --freeswitch.proxy-url. Labels are the target labels of
+// the target in the service discovery endpoint.
+type Target struct {
+	URI          string            `yaml:"uri"`
+	User         string            `yaml:"user"`
+	Password     stringList        `yaml:"password"`
+	PasswordFile stringList        `yaml:"password_file"`
+	Proxy        string            `yaml:"proxy"`
+	Labels       map[string]string `yaml:"labels"`
+}
+
+// stringList is a list of strings, which can be a single string in YAML. An
+// empty string is an empty list.
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+
+	if err := unmarshal(&s); err == nil {
+		*l = nil
+
+		if len(s) != 0 {
+			*l = stringList{s}
+		}
+
+		return nil
+	}
+
+	return unmarshal((*[]string)(l))
+}
+
+// LoadConfig reads, parses and validates the configuration file.
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+
+	var config Config
+
+	if err = yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse config: %w", err)
+	}
+
+	if err = config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for i, target := range config.Targets {
+		for _, file := range target.PasswordFile {
+			password, err := readPasswordFile(file)
+
+			if err != nil {
+				return nil, fmt.Errorf("target %q: %w", target.URI, err)
+			}
+
+			config.Targets[i].Password = append(config.Targets[i].Password, password)
+		}
+	}
+
+	for name, module := range config.AuthModules {
+		for _, file := range module.PasswordFile {
+			password, err := readPasswordFile(file)
+
+			if err != nil {
+				return nil, fmt.Errorf("auth module %q: %w", name, err)
+			}
+
+			module.Password = append(module.Password, password)
+		}
+
+		config.AuthModules[name] = module
+	}
+
+	return &config, nil
+}
+
+// validate checks the parts of the configuration that are not flags, flag
+// values are checked when parsing flags.
+func (config *Config) validate() error {
+	for _, def := range config.ChannelVariables {
+		if err := def.Validate(); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+
+	for _, def := range config.Commands {
+		if err := def.Validate(); err != nil {
+			return err
+		}
+
+		if names[def.Name] {
+			return fmt.Errorf("command metric %q: duplicate", def.Name)
+		}
+
+		names[def.Name] = true
+	}
+
+	for _, pattern := range config.EmergencyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("emergency pattern %q: %w", pattern, err)
+		}
+	}
+
+	for profile, file := range config.TLSCertificates {
+		if len(file) == 0 {
+			return fmt.Errorf("tls certificate of profile %q: empty file", profile)
+		}
+	}
+
+	if config.RemoteWrite != nil {
+		if err := config.RemoteWrite.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.Role != nil {
+		if err := config.Role.Validate(); err != nil {
+			return err
+		}
+	}
+
+	renamed := make(map[string]bool)
+
+	for _, def := range config.MetricRenames {
+		if err := def.validate(); err != nil {
+			return err
+		}
+
+		if renamed[def.From] {
+			return fmt.Errorf("metric rename %q: duplicate", def.From)
+		}
+
+		renamed[def.From] = true
+	}
+
+	for name, module := range config.AuthModules {
+		if err := module.validate(name); err != nil {
+			return err
+		}
+	}
+
+	uris := make(map[string]bool)
+
+	for _, target := range config.Targets {
+		if err := validateURI(target.URI); err != nil {
+			return fmt.Errorf("target %q: %w", target.URI, err)
+		}
+
+		if uris[target.URI] {
+			return fmt.Errorf("target %q: duplicate", target.URI)
+		}
+
+		if len(target.Password) != 0 && len(target.PasswordFile) != 0 {
+			return fmt.Errorf("target %q: password and password_file are mutually exclusive", target.URI)
+		}
+
+		for name := range target.Labels {
+			if !model.LabelName(name).IsValid() {
+				return fmt.Errorf("target %q: label %q: invalid name", target.URI, name)
+			}
+		}
+
+		uris[target.URI] = true
+	}
+
+	return nil
+}
+
+// flagDefaults returns the flag values of the configuration file, keyed by
+// flag name.
+func (config *Config) flagDefaults() (map[string][]string, error) {
+	defaults := make(map[string][]string)
+
+	sections := map[string]map[string]interface{}{
+		"web":        config.Web,
+		"freeswitch": config.FreeSWITCH,
+		"collector":  config.Collector,
+		"metrics":    config.Metrics,
+	}
+
+	for prefix, section := range sections {
+		for key, value := range section {
+			name := prefix + "." + strings.ReplaceAll(key, "_", "-")
+
+			switch v := value.(type) {
+			case []interface{}:
+				for _, item := range v {
+					defaults[name] = append(defaults[name], fmt.Sprint(item))
+				}
+			case map[interface{}]interface{}:
+				return nil, fmt.Errorf("%s.%s: a scalar or a list is expected", prefix, key)
+			case nil:
+				defaults[name] = nil
+			default:
+				defaults[name] = []string{fmt.Sprint(v)}
+			}
+		}
+	}
+
+	return defaults, nil
+}
+
+// readPasswordFile returns the content of filename, without trailing line
+// breaks.
+func readPasswordFile(filename string) (string, error) {
+	content, err := os.ReadFile(filename)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot read password file: %w", err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// validateURI checks that uri is a scrape URI, e.g. tcp://localhost:8021.
+func validateURI(uri string) error {
+	for _, uri := range strings.Split(uri, "|") {
+		u, err := url.Parse(uri)
+
+		if err != nil {
+			return err
+		}
+
+		if len(u.Scheme) == 0 {
+			return errors.New("missing scheme")
+		}
+	}
+
+	return nil
+}
+
+// parseFlags parses the command line. The flag values of the configuration
+// file (--config.file) are used as defaults, so that flags given on the
+// command line take precedence. It returns the configuration file, or an
+// empty configuration if there is none.
+func parseFlags(app *kingpin.Application, args []string) (*Config, error) {
+	config := &Config{}
+
+	// errors are reported by Parse below
+	if context, err := app.ParseContext(args); err == nil {
+		for _, element := range context.Elements {
+			flag, ok := element.Clause.(*kingpin.FlagClause)
+
+			if !ok || flag.Model().Name != "config.file" || element.Value == nil {
+				continue
+			}
+
+			if config, err = LoadConfig(*element.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	defaults, err := config.flagDefaults()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	names := make([]string, 0, len(defaults))
+
+	for name := range defaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := app.GetFlag(name)
+
+		if flag == nil {
+			return nil, fmt.Errorf("invalid config: unknown option %q", name)
+		}
+
+		flag.Default(defaults[name]...)
+	}
+
+	if _, err = app.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+)
+
+// eslDebugHandler shows the raw responses of the last commands sent by the
+// collectors of e, as text.
+func eslDebugHandler(e *exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		instances := e.instances()
+		names := make([]string, 0, len(instances))
+
+		for name := range instances {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if len(name) != 0 {
+				fmt.Fprintf(w, "## %s\n\n", name)
+			}
+
+			writeResponses(w, instances[name].Responses())
+		}
+	})
+}
+
+// pprofHandler serves the runtime profiles of the exporter under
+// /debug/pprof/, as net/http/pprof does on the default mux.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// writeResponses writes responses as text to w.
+func writeResponses(w io.Writer, responses []collector.Response) {
+	for _, response := range responses {
+		fmt.Fprintf(w, "### %s (%s, %v)\n", response.Command, response.Sent.Format(time.RFC3339), response.Duration.Round(time.Microsecond))
+
+		if response.Err != nil {
+			fmt.Fprintf(w, "error: %v\n\n", response.Err)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\n\n", response.Body)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// discovery maintains the collectors of the FreeSWITCH instances found in a
+// DNS SRV record and a targets file, looked up again every interval.
+type discovery struct {
+	srv      string
+	file     string
+	interval time.Duration
+	create   func(uri string) (*collector.Collector, error)
+	logger   log.Logger
+
+	mutex      sync.Mutex
+	collectors map[string]*collector.Collector
+
+	stop chan struct{}
+}
+
+// newDiscovery looks up the instances, and keeps looking them up in the
+// background until Close. Collectors are created with create.
+func newDiscovery(srv, file string, interval time.Duration, create func(uri string) (*collector.Collector, error), logger log.Logger) (*discovery, error) {
+	d := &discovery{
+		srv:        srv,
+		file:       file,
+		interval:   interval,
+		create:     create,
+		logger:     logger,
+		collectors: make(map[string]*collector.Collector),
+		stop:       make(chan struct{}),
+	}
+
+	// the first lookup must succeed, later failures keep the instances
+	if err := d.refresh(); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	go d.run()
+
+	return d, nil
+}
+
+func (d *discovery) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.refresh(); err != nil {
+				level.Warn(d.logger).Log("msg", "Cannot discover targets, keeping the previous ones", "err", err)
+			}
+		}
+	}
+}
+
+// refresh looks up the instances, creating the collectors of the new ones and
+// closing those of the instances that are gone.
+func (d *discovery) refresh() error {
+	uris, err := d.lookup()
+
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// closed during the lookup
+	select {
+	case <-d.stop:
+		return nil
+	default:
+	}
+
+	for _, uri := range uris {
+		found[uri] = true
+
+		if _, ok := d.collectors[uri]; ok {
+			continue
+		}
+
+		c, err := d.create(uri)
+
+		if err != nil {
+			return fmt.Errorf("target %q: %w", uri, err)
+		}
+
+		level.Info(d.logger).Log("msg", "Target discovered", "target", uri)
+		d.collectors[uri] = c
+	}
+
+	for uri, c := range d.collectors {
+		if !found[uri] {
+			level.Info(d.logger).Log("msg", "Target removed", "target", uri)
+			c.Close()
+			delete(d.collectors, uri)
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the URIs of the SRV record and of the targets file, sorted.
+func (d *discovery) lookup() ([]string, error) {
+	var uris []string
+
+	if len(d.srv) != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+		defer cancel()
+
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.srv)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			uris = append(uris, "tcp://"+net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+	}
+
+	if len(d.file) != 0 {
+		content, err := os.ReadFile(d.file)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read targets file: %w", err)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+
+		for scanner.Scan() {
+			uri := strings.TrimSpace(scanner.Text())
+
+			if len(uri) == 0 || strings.HasPrefix(uri, "#") {
+				continue
+			}
+
+			if err := validateURI(uri); err != nil {
+				return nil, fmt.Errorf("targets file, %q: %w", uri, err)
+			}
+
+			uris = append(uris, uri)
+		}
+	}
+
+	sort.Strings(uris)
+
+	return uris, nil
+}
+
+// Collectors returns the collectors of the instances, by URI.
+func (d *discovery) Collectors() map[string]*collector.Collector {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	collectors := make(map[string]*collector.Collector, len(d.collectors))
+
+	for uri, c := range d.collectors {
+		collectors[uri] = c
+	}
+
+	return collectors
+}
+
+// Close stops looking up the instances, and closes their collectors.
+func (d *discovery) Close() {
+	d.close(nil)
+}
+
+// close stops looking up the instances, and closes their collectors but those
+// in keep.
+func (d *discovery) close(keep map[*collector.Collector]bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+
+	for uri, c := range d.collectors {
+		if !keep[c] {
+			c.Close()
+		}
+
+		delete(d.collectors, uri)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricFilter drops the metrics whose names match none of include, if not
+// empty, or match one of exclude, see --metrics.include and --metrics.exclude.
+type metricFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newMetricFilter returns the filter of the regular expressions include and
+// exclude, anchored like relabeling ones, or nil if both are empty.
+func newMetricFilter(include, exclude []string) (*metricFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	var (
+		f   metricFilter
+		err error
+	)
+
+	if f.include, err = compileNames("include", include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = compileNames("exclude", exclude); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+func compileNames(flag string, patterns []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+
+		if err != nil {
+			return nil, fmt.Errorf("metrics %s %q: %w", flag, pattern, err)
+		}
+
+		regexps = append(regexps, re)
+	}
+
+	return regexps, nil
+}
+
+// keep returns whether the metrics named name are exposed, true if f is nil.
+func (f *metricFilter) keep(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withFilter returns a gatherer dropping the metrics of g filtered out by f,
+// by their exposed name, or g if f is nil.
+func withFilter(g prometheus.Gatherer, f *metricFilter) prometheus.Gatherer {
+	if f == nil {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		kept := families[:0]
+
+		for _, family := range families {
+			if f.keep(family.GetName()) {
+				kept = append(kept, family)
+			}
+		}
+
+		return kept, err
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jsonFamily is a metric family of the JSON endpoint.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+// jsonMetric is a metric of the JSON endpoint. Counters, gauges and untyped
+// metrics have a value, histograms buckets and summaries quantiles.
+type jsonMetric struct {
+	Labels    map[string]string    `json:"labels"`
+	Value     *jsonValue           `json:"value,omitempty"`
+	Buckets   map[string]jsonValue `json:"buckets,omitempty"`
+	Quantiles map[string]jsonValue `json:"quantiles,omitempty"`
+	Sum       *jsonValue           `json:"sum,omitempty"`
+	Count     *jsonValue           `json:"count,omitempty"`
+	Timestamp int64                `json:"timestamp_ms,omitempty"`
+}
+
+// jsonValue is a sample value, encoded as null if it is not finite, as JSON
+// has no NaN or infinity.
+type jsonValue float64
+
+// MarshalJSON implements json.Marshaler.
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+
+	return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+}
+
+func newJSONValue(f float64) *jsonValue {
+	v := jsonValue(f)
+	return &v
+}
+
+// jsonHandler serves the metrics of the gatherer returned by gather for each
+// request as JSON, for consumers that cannot parse the Prometheus formats.
+func jsonHandler(gather func(r *http.Request) (prometheus.Gatherer, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherer, err := gather(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		families, err := gatherer.Gather()
+
+		if err != nil {
+			http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]jsonFamily, 0, len(families))
+
+		for _, family := range families {
+			result = append(result, newJSONFamily(family))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// newJSONFamily converts family to JSON.
+func newJSONFamily(family *dto.MetricFamily) jsonFamily {
+	result := jsonFamily{
+		Name:    family.GetName(),
+		Help:    family.GetHelp(),
+		Type:    strings.ToLower(family.GetType().String()),
+		Metrics: make([]jsonMetric, 0, len(family.GetMetric())),
+	}
+
+	for _, m := range family.GetMetric() {
+		metric := jsonMetric{
+			Labels:    make(map[string]string, len(m.GetLabel())),
+			Timestamp: m.GetTimestampMs(),
+		}
+
+		for _, label := range m.GetLabel() {
+			metric.Labels[label.GetName()] = label.GetValue()
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Value = newJSONValue(m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			metric.Value = newJSONValue(m.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			metric.Value = newJSONValue(m.GetUntyped().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			metric.Buckets = make(map[string]jsonValue, len(h.GetBucket())+1)
+
+			for _, bucket := range h.GetBucket() {
+				metric.Buckets[strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)] = jsonValue(bucket.GetCumulativeCount())
+			}
+
+			metric.Buckets["+Inf"] = jsonValue(h.GetSampleCount())
+			metric.Sum = newJSONValue(h.GetSampleSum())
+			metric.Count = newJSONValue(float64(h.GetSampleCount()))
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			metric.Quantiles = make(map[string]jsonValue, len(s.GetQuantile()))
+
+			for _, quantile := range s.GetQuantile() {
+				metric.Quantiles[strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)] = jsonValue(quantile.GetValue())
+			}
+
+			metric.Sum = newJSONValue(s.GetSampleSum())
+			metric.Count = newJSONValue(float64(s.GetSampleCount()))
+		}
+
+		result.Metrics = append(result.Metrics, metric)
+	}
+
+	return result
+}
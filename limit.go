@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestLimiter bounds the number of scrape requests served at once, the
+// requests beyond the limit being rejected with 503 rather than waiting for
+// the scrape in progress, e.g. when FreeSWITCH hangs.
+type requestLimiter struct {
+	slots    chan struct{}
+	rejected prometheus.Counter
+}
+
+// newRequestLimiter returns a limiter of max requests, or nil if max is 0.
+func newRequestLimiter(max int) *requestLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &requestLimiter{
+		slots: make(chan struct{}, max),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: "exporter",
+			Name:      "rejected_requests_total",
+			Help:      "Number of scrape requests rejected as --web.max-requests were being served.",
+		}),
+	}
+}
+
+// handler serves the requests with next, sharing the limit with the other
+// handlers of l. It returns next if l is nil.
+func (l *requestLimiter) handler(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.rejected.Inc()
+			http.Error(w, "Too many scrape requests in progress.", http.StatusServiceUnavailable)
+			return
+		}
+
+		defer func() { <-l.slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (l *requestLimiter) Describe(ch chan<- *prometheus.Desc) {
+	l.rejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *requestLimiter) Collect(ch chan<- prometheus.Metric) {
+	l.rejected.Collect(ch)
+}
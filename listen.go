@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// listen returns listeners on addresses, the values of --web.listen-address:
+// host:port addresses, or the path of a unix socket prefixed with unix://.
+// The stale socket of a previous run is removed.
+func listen(addresses []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, address := range addresses {
+		network := "tcp"
+
+		if strings.HasPrefix(address, "unix://") {
+			network, address = "unix", strings.TrimPrefix(address, "unix://")
+
+			if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+				os.Remove(address)
+			}
+		}
+
+		listener, err := net.Listen(network, address)
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("listen address %q: %w", address, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// validateNamespace checks that namespace, without trailing underscores, can
+// prefix metric names.
+func validateNamespace(namespace string) (string, error) {
+	namespace = strings.TrimRight(namespace, "_")
+
+	if !model.IsValidMetricName(model.LabelValue(namespace + "_up")) {
+		return "", fmt.Errorf("namespace %q: invalid metric name prefix", namespace)
+	}
+
+	return namespace, nil
+}
+
+// withNamespace returns a gatherer renaming the metrics of g in the freeswitch
+// namespace to namespace, e.g. freeswitch_up to fs_edge_up. The metrics of the
+// exporter itself (freeswitch_exporter_*) keep their name, so that the
+// standard dashboards and alerts on them still apply.
+func withNamespace(g prometheus.Gatherer, namespace string) prometheus.Gatherer {
+	if namespace == collector.Namespace {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+
+		for _, family := range families {
+			name := family.GetName()
+
+			if strings.HasPrefix(name, collector.Namespace+"_") && !strings.HasPrefix(name, collector.Namespace+"_exporter_") {
+				name = namespace + strings.TrimPrefix(name, collector.Namespace)
+				family.Name = &name
+			}
+		}
+
+		sort.Slice(families, func(i, j int) bool {
+			return families[i].GetName() < families[j].GetName()
+		})
+
+		return families, err
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNamespace(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(constCollector{
+		prometheus.MustNewConstMetric(prometheus.NewDesc("freeswitch_up", "Was the last scrape successful", nil, nil), prometheus.GaugeValue, 1),
+		prometheus.MustNewConstMetric(prometheus.NewDesc("freeswitch_exporter_total_scrapes", "Current total FreeSWITCH scrapes", nil, nil), prometheus.CounterValue, 3),
+		prometheus.MustNewConstMetric(prometheus.NewDesc("go_goroutines", "Number of goroutines that currently exist", nil, nil), prometheus.GaugeValue, 8),
+	})
+
+	families, err := withNamespace(registry, "fs_edge").Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+
+	want := []string{"freeswitch_exporter_total_scrapes", "fs_edge_up", "go_goroutines"}
+
+	if len(names) != len(want) {
+		t.Fatalf("got %q, want %q", names, want)
+	}
+
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("got %q, want %q", names, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrapeOnce scrapes FreeSWITCH with the collectors of e and writes the metrics
+// to w in the text format. It fails if FreeSWITCH cannot be scraped or if a
+// collector fails, once all the metrics are written.
+func scrapeOnce(w io.Writer, e *exporter) error {
+	registry, err := e.newScrape(context.Background(), time.Time{}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	families, err := registry.Gather()
+
+	if err != nil {
+		return err
+	}
+
+	var failure error
+
+	// failures are detected before the metrics are renamed or filtered out
+	for _, family := range families {
+		switch family.GetName() {
+		case collector.Namespace + "_up":
+			for _, m := range family.GetMetric() {
+				if m.GetGauge().GetValue() == 0 {
+					failure = errors.New("cannot scrape FreeSWITCH")
+				}
+			}
+		case collector.Namespace + "_collector_success":
+			for _, m := range family.GetMetric() {
+				if m.GetGauge().GetValue() == 0 && failure == nil {
+					failure = fmt.Errorf("collector %s failed", m.GetLabel()[0].GetValue())
+				}
+			}
+		}
+	}
+
+	exposed, err := e.expose(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})).Gather()
+
+	if err != nil {
+		return err
+	}
+
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+
+	for _, family := range exposed {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	return failure
+}
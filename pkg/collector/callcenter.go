@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// memberStates are the states of the queue members of mod_callcenter, exported
+// at 0 when no member is in them, so that each queue has a series per state
+// even when it is empty.
+var memberStates = []string{"Unknown", "Waiting", "Trying", "Answered", "Abandoned"}
+
+// scrapeCallcenter exports the number of mod_callcenter agents by status and
+// state, and the number of members of each queue by state.
+func (c *Collector) scrapeCallcenter(ch chan<- prometheus.Metric) error {
+	agents, err := c.fetchCallcenterList("api callcenter_config agent list")
+
+	if err != nil {
+		return err
+	}
+
+	type agentKey struct{ status, state string }
+	counts := make(map[agentKey]float64)
+
+	for _, agent := range agents {
+		counts[agentKey{agent["status"], agent["state"]}]++
+	}
+
+	desc := prometheus.NewDesc(Namespace+"_callcenter_agents", "Number of callcenter agents, by status and state", []string{"status", "state"}, nil)
+
+	for key, count := range counts {
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, count, key.status, key.state)
+
+		if err != nil {
+			return err
+		}
+
+		ch <- metric
+	}
+
+	queues, err := c.fetchCallcenterList("api callcenter_config queue list")
+
+	if err != nil {
+		return err
+	}
+
+	desc = prometheus.NewDesc(Namespace+"_callcenter_queue_members", "Number of callers in the callcenter queue, by state", []string{"queue", "state"}, nil)
+
+	for _, queue := range queues {
+		members, err := c.fetchCallcenterList("api callcenter_config queue list members " + queue["name"])
+
+		if err != nil {
+			return err
+		}
+
+		states := make(map[string]float64, len(memberStates))
+
+		for _, state := range memberStates {
+			states[state] = 0
+		}
+
+		for _, member := range members {
+			states[member["state"]]++
+		}
+
+		for state, count := range states {
+			metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, count, queue["name"], state)
+
+			if err != nil {
+				return err
+			}
+
+			ch <- metric
+		}
+	}
+
+	return nil
+}
+
+// fetchCallcenterList returns the rows of a callcenter_config list command,
+// a table of "|" separated columns with a header line, ending with +OK.
+func (c *Collector) fetchCallcenterList(command string) ([]map[string]string, error) {
+	response, err := c.fsCommand(command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(response, []byte("-ERR")) {
+		return nil, newErrorReply(command, response)
+	}
+
+	var header []string
+	var rows []map[string]string
+
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || line == "+OK" {
+			continue
+		}
+
+		fields := strings.Split(line, "|")
+
+		if header == nil {
+			header = fields
+			continue
+		}
+
+		row := make(map[string]string, len(header))
+
+		for i, name := range header {
+			if i < len(fields) {
+				row[name] = fields[i]
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,215 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// callTracker follows the active calls from channel events, and exports the
+// peak number of concurrent calls over configurable windows. Calls are counted
+// like "show calls" does, the channels of a call sharing the same call UUID.
+type callTracker struct {
+	windows []time.Duration
+	desc    *prometheus.Desc
+
+	mutex    sync.Mutex
+	channels map[string]string // channel UUID to call UUID
+	calls    map[string]int    // call UUID to number of channels
+	last     int
+
+	// slots hold the peak of each second of the longest window
+	slots []peakSlot
+}
+
+type peakSlot struct {
+	second int64
+	enter  int
+	peak   int
+}
+
+func newCallTracker(windows []time.Duration) (*callTracker, error) {
+	var longest time.Duration
+
+	for _, w := range windows {
+		if w < time.Second {
+			return nil, fmt.Errorf("calls peak window %v: must be at least one second", w)
+		}
+
+		if w > longest {
+			longest = w
+		}
+	}
+
+	return &callTracker{
+		windows:  windows,
+		desc:     prometheus.NewDesc(Namespace+"_current_calls_peak", "Peak number of concurrent calls over the window", []string{"window"}, nil),
+		channels: make(map[string]string),
+		calls:    make(map[string]int),
+		slots:    make([]peakSlot, int(longest/time.Second)),
+	}, nil
+}
+
+// register subscribes the tracker to channel events.
+func (t *callTracker) register(l *eventListener) {
+	l.onConnect("api show channels as json", t.sync)
+	l.onDisconnect(t.clear)
+
+	for _, name := range []string{"CHANNEL_CREATE", "CHANNEL_BRIDGE", "CHANNEL_UNBRIDGE"} {
+		l.handle(name, t.handleChannel)
+	}
+
+	l.handle("CHANNEL_DESTROY", t.handleDestroy)
+	l.handle("CHANNEL_UUID", t.handleUUID)
+}
+
+// sync initializes the active calls from "show channels".
+func (t *callTracker) sync(response []byte) error {
+	r := struct {
+		Rows []struct {
+			UUID     string `json:"uuid"`
+			CallUUID string `json:"call_uuid"`
+		} `json:"rows"`
+	}{}
+
+	if err := json.Unmarshal(response, &r); err != nil {
+		return fmt.Errorf("cannot read channels: %w", err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.channels = make(map[string]string)
+	t.calls = make(map[string]int)
+
+	for _, row := range r.Rows {
+		t.set(row.UUID, row.CallUUID)
+	}
+
+	t.record()
+
+	return nil
+}
+
+func (t *callTracker) clear() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.channels = make(map[string]string)
+	t.calls = make(map[string]int)
+	t.record()
+}
+
+func (t *callTracker) handleChannel(event Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.set(event["Unique-ID"], event["Channel-Call-UUID"])
+	t.record()
+}
+
+func (t *callTracker) handleDestroy(event Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.remove(event["Unique-ID"])
+	t.record()
+}
+
+func (t *callTracker) handleUUID(event Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.remove(event["Old-Unique-ID"])
+	t.set(event["Unique-ID"], event["Channel-Call-UUID"])
+	t.record()
+}
+
+// set attaches the channel to the call, a channel without call UUID being its
+// own call. It is a no-op if the channel is already attached to the call.
+func (t *callTracker) set(uuid, callUUID string) {
+	if len(callUUID) == 0 {
+		callUUID = uuid
+	}
+
+	if previous, ok := t.channels[uuid]; ok {
+		if previous == callUUID {
+			return
+		}
+
+		t.remove(uuid)
+	}
+
+	t.channels[uuid] = callUUID
+	t.calls[callUUID]++
+}
+
+func (t *callTracker) remove(uuid string) {
+	callUUID, ok := t.channels[uuid]
+
+	if !ok {
+		return
+	}
+
+	delete(t.channels, uuid)
+
+	if t.calls[callUUID]--; t.calls[callUUID] <= 0 {
+		delete(t.calls, callUUID)
+	}
+}
+
+// record updates the peak of the current second.
+func (t *callTracker) record() {
+	now := time.Now().Unix()
+	slot := &t.slots[now%int64(len(t.slots))]
+
+	if slot.second != now {
+		*slot = peakSlot{second: now, enter: t.last, peak: t.last}
+	}
+
+	t.last = len(t.calls)
+
+	if t.last > slot.peak {
+		slot.peak = t.last
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (t *callTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+// Collect implements prometheus.Collector.
+func (t *callTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now().Unix()
+
+	for _, w := range t.windows {
+		peak := t.last
+
+		for i := int64(0); i < int64(w/time.Second); i++ {
+			slot := t.slots[(now-i)%int64(len(t.slots))]
+
+			if slot.second != now-i {
+				continue
+			}
+
+			// the level when entering the slot lasted since the previous one
+			if slot.enter > peak {
+				peak = slot.enter
+			}
+
+			if slot.peak > peak {
+				peak = slot.peak
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, float64(peak), model.Duration(w).String())
+	}
+}
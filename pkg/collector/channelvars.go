@@ -0,0 +1,254 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// ChannelVariable declares a metric extracted from the channel variables of
+// CHANNEL_HANGUP_COMPLETE events, or, if Source is "channels", from the
+// columns of the live channels listed by "show channels as json" on every
+// scrape.
+//
+// Counters are incremented by the value of Variable, or by one if Variable is
+// empty. Gauges are set to the value of the last channel hung up, histograms
+// observe the value of every channel. Labels maps label names to the channel
+// variables holding their values. With the channels source, the metric is a
+// gauge of the number of live channels, or of the sum of their Variable
+// column, by labels.
+type ChannelVariable struct {
+	Name     string            `yaml:"name"`
+	Help     string            `yaml:"help"`
+	Type     string            `yaml:"type"`
+	Source   string            `yaml:"source"`
+	Variable string            `yaml:"variable"`
+	Buckets  []float64         `yaml:"buckets"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// channelVariableMetric is the metric vector of a ChannelVariable.
+type channelVariableMetric struct {
+	variable  string
+	labels    []string
+	collector prometheus.Collector
+	update    func(labels []string, value float64)
+
+	// desc of the metrics of the channels source, nil for events
+	desc *prometheus.Desc
+}
+
+// Validate checks that def can be exported.
+func (def ChannelVariable) Validate() error {
+	_, err := newChannelVariableMetric(def)
+	return err
+}
+
+func newChannelVariableMetric(def ChannelVariable) (*channelVariableMetric, error) {
+	if !model.IsValidMetricName(model.LabelValue(Namespace + "_" + def.Name)) {
+		return nil, fmt.Errorf("channel variable metric %q: invalid name", def.Name)
+	}
+
+	if len(def.Variable) == 0 && def.Type != "counter" && def.Source != "channels" {
+		return nil, fmt.Errorf("channel variable metric %q: variable is required", def.Name)
+	}
+
+	m := channelVariableMetric{
+		variable: def.Variable,
+	}
+
+	var labelNames []string
+
+	for label := range def.Labels {
+		if !model.LabelName(label).IsValid() {
+			return nil, fmt.Errorf("channel variable metric %q: invalid label %q", def.Name, label)
+		}
+
+		labelNames = append(labelNames, label)
+	}
+
+	sort.Strings(labelNames)
+
+	for _, label := range labelNames {
+		m.labels = append(m.labels, def.Labels[label])
+	}
+
+	help := def.Help
+
+	switch def.Source {
+	case "", "hangup":
+	case "channels":
+		if def.Type != "gauge" {
+			return nil, fmt.Errorf("channel variable metric %q: only gauges can be read from channels", def.Name)
+		}
+
+		if len(help) == 0 {
+			help = "Number of channels active"
+
+			if len(def.Variable) != 0 {
+				help = fmt.Sprintf("Sum of %s of the channels active", def.Variable)
+			}
+		}
+
+		m.desc = prometheus.NewDesc(Namespace+"_"+def.Name, help, labelNames, nil)
+
+		return &m, nil
+	default:
+		return nil, fmt.Errorf("channel variable metric %q: unknown source %q", def.Name, def.Source)
+	}
+
+	if len(help) == 0 {
+		help = fmt.Sprintf("Channel variable %s of hung up channels", def.Variable)
+	}
+
+	switch def.Type {
+	case "counter":
+		vec := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      def.Name,
+			Help:      help,
+		}, labelNames)
+
+		m.collector = vec
+		m.update = func(labels []string, value float64) {
+			vec.WithLabelValues(labels...).Add(value)
+		}
+	case "gauge":
+		vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      def.Name,
+			Help:      help,
+		}, labelNames)
+
+		m.collector = vec
+		m.update = func(labels []string, value float64) {
+			vec.WithLabelValues(labels...).Set(value)
+		}
+	case "histogram":
+		vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      def.Name,
+			Help:      help,
+			Buckets:   def.Buckets,
+		}, labelNames)
+
+		m.collector = vec
+		m.update = func(labels []string, value float64) {
+			vec.WithLabelValues(labels...).Observe(value)
+		}
+	default:
+		return nil, fmt.Errorf("channel variable metric %q: unknown type %q", def.Name, def.Type)
+	}
+
+	return &m, nil
+}
+
+// handleHangup updates the metric from a CHANNEL_HANGUP_COMPLETE event.
+// Channels without the variable, or with a non numeric value, are ignored.
+func (m *channelVariableMetric) handleHangup(event Event) {
+	value := 1.0
+
+	if len(m.variable) != 0 {
+		var err error
+
+		value, err = strconv.ParseFloat(event["variable_"+m.variable], 64)
+
+		if err != nil {
+			return
+		}
+	}
+
+	labels := make([]string, len(m.labels))
+
+	for i, variable := range m.labels {
+		labels[i] = event["variable_"+variable]
+	}
+
+	m.update(labels, value)
+}
+
+// scrapeChannels exports the metrics of the channels source, from the live
+// channels listed by "show channels as json". Nothing is sent without them.
+func (c *Collector) scrapeChannels(ch chan<- prometheus.Metric) error {
+	if len(c.channelMetrics) == 0 {
+		return nil
+	}
+
+	response, err := c.fsCommand("api show channels as json")
+
+	if err != nil {
+		return err
+	}
+
+	r := struct {
+		Rows []map[string]string `json:"rows"`
+	}{}
+
+	if err = json.Unmarshal(response, &r); err != nil {
+		return fmt.Errorf("cannot read JSON response: %w", err)
+	}
+
+	for _, m := range c.channelMetrics {
+		if err := m.collectChannels(ch, r.Rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectChannels sends the gauges of m for channels, by label values.
+// Channels with a non numeric value of the variable are ignored.
+func (m *channelVariableMetric) collectChannels(ch chan<- prometheus.Metric, channels []map[string]string) error {
+	type sample struct {
+		labels []string
+		value  float64
+	}
+
+	samples := make(map[string]*sample)
+	var keys []string
+
+	for _, channel := range channels {
+		value := 1.0
+
+		if len(m.variable) != 0 {
+			var err error
+
+			if value, err = strconv.ParseFloat(channel[m.variable], 64); err != nil {
+				continue
+			}
+		}
+
+		labels := make([]string, len(m.labels))
+
+		for i, column := range m.labels {
+			labels[i] = channel[column]
+		}
+
+		key := strings.Join(labels, "\xff")
+
+		if _, ok := samples[key]; !ok {
+			samples[key] = &sample{labels: labels}
+			keys = append(keys, key)
+		}
+
+		samples[key].value += value
+	}
+
+	for _, key := range keys {
+		metric, err := prometheus.NewConstMetric(m.desc, prometheus.GaugeValue, samples[key].value, samples[key].labels...)
+
+		if err != nil {
+			return err
+		}
+
+		ch <- metric
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeClock exports the FreeSWITCH time, its offset from the exporter
+// clock, and whether it is within the time sync tolerance.
+func (c *Collector) scrapeClock(ch chan<- prometheus.Metric) error {
+	response, sent, received, err := c.fsTimedCommand("api strepoch")
+
+	if err != nil {
+		return err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(string(response)), 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("cannot read FreeSWITCH time: %w", err)
+	}
+
+	// strepoch is truncated to the second, and was read at some point of the
+	// round trip: both are assumed to be in the middle
+	freeswitch := time.Unix(value, 0).Add(500 * time.Millisecond)
+	local := sent.Add(received.Sub(sent) / 2)
+	offset := freeswitch.Sub(local).Seconds()
+
+	synced := 1.0
+
+	if math.Abs(offset) > c.timeSyncTolerance.Seconds() {
+		synced = 0
+
+		level.Warn(c.logger).Log("msg", "Time not in sync between system and FreeSWITCH", "target", c.URI,
+			"offset", fmt.Sprintf("%.3fs", offset), "tolerance", c.timeSyncTolerance)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(Namespace+"_time_seconds", "FreeSWITCH time in seconds since the epoch (api strepoch, 1s resolution)", nil, nil),
+		prometheus.GaugeValue,
+		float64(value),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(Namespace+"_clock_offset_seconds", "Offset of FreeSWITCH time from exporter host time (1s resolution)", nil, nil),
+		prometheus.GaugeValue,
+		offset,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(Namespace+"_time_synced", "Is FreeSWITCH time in sync with exporter host time", nil, nil),
+		prometheus.GaugeValue,
+		synced,
+	)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// codecAliases maps IANA names to the names listed by "show codec" when
+	// they differ.
+	codecAliases = map[string]string{
+		"pcmu": "g711ulaw",
+		"pcma": "g711alaw",
+	}
+)
+
+// scrapeCodecs exports the number of loaded codecs, and whether each of the
+// configured codecs is loaded.
+func (c *Collector) scrapeCodecs(ch chan<- prometheus.Metric) error {
+	response, err := c.fsCommand("api show codec as json")
+
+	if err != nil {
+		return err
+	}
+
+	r := struct {
+		Count float64 `json:"row_count"`
+		Rows  []struct {
+			Name string `json:"name"`
+		} `json:"rows"`
+	}{}
+
+	if err = json.Unmarshal(response, &r); err != nil {
+		return fmt.Errorf("cannot read JSON response: %w", err)
+	}
+
+	metric, err := prometheus.NewConstMetric(
+		prometheus.NewDesc(Namespace+"_loaded_codecs", "Number of codecs loaded", nil, nil),
+		prometheus.GaugeValue,
+		r.Count,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	ch <- metric
+
+	desc := prometheus.NewDesc(Namespace+"_codec_available", "Is the codec loaded", []string{"codec"}, nil)
+
+	for _, codec := range c.codecs {
+		value := 0.0
+
+		for _, row := range r.Rows {
+			if codecMatches(row.Name, codec) {
+				value = 1
+				break
+			}
+		}
+
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, codec)
+
+		if err != nil {
+			return err
+		}
+
+		ch <- metric
+	}
+
+	return nil
+}
+
+// codecMatches reports whether name, as listed by "show codec" (e.g. "G.729"
+// or "OPUS (STANDARD)"), is the codec wanted (e.g. "G729" or "OPUS"). Case and
+// punctuation are ignored.
+func codecMatches(name, wanted string) bool {
+	w := normalizeCodec(wanted)
+
+	if alias, ok := codecAliases[w]; ok {
+		w = alias
+	}
+
+	if normalizeCodec(name) == w {
+		return true
+	}
+
+	if fields := strings.Fields(name); len(fields) > 0 {
+		return normalizeCodec(fields[0]) == w
+	}
+
+	return false
+}
+
+func normalizeCodec(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return -1
+	}, name)
+}
@@ -0,0 +1,566 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/eslmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const sofiaXMLStatus = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<profiles>
+<profile>
+<name>external</name>
+<type>profile</type>
+<data>sip:mod_sofia@10.0.0.1:5080</data>
+<state>RUNNING (0)</state>
+</profile>
+<gateway>
+<name>external::carrier1</name>
+<type>gateway</type>
+<data>sip:user@carrier1.com</data>
+<state>REGED</state>
+</gateway>
+<gateway>
+<name>external::carrier2</name>
+<type>gateway</type>
+<data>sip:user@carrier2.com</data>
+<state>FAIL_WAIT</state>
+</gateway>
+<alias>
+<name>10.0.0.1</name>
+<type>alias</type>
+<data>external</data>
+<state>ALIASED</state>
+</alias>
+</profiles>
+`
+
+const sofiaStatus = `                     Name	   Type	                                       Data	State
+=================================================================================================
+            external	profile	            sip:mod_sofia@10.0.0.1:5080	RUNNING (0)
+   external::carrier1	gateway	                    sip:user@carrier1.com	REGED
+   external::carrier2	gateway	                    sip:user@carrier2.com	FAIL_WAIT
+            10.0.0.1	  alias	                                   external	ALIASED
+=================================================================================================
+1 profile 1 alias
+`
+
+// newTestServer returns a mock event socket closed at the end of the test.
+func newTestServer(t *testing.T) *eslmock.Server {
+	t.Helper()
+
+	server, err := eslmock.New("ClueCon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+// newTestCollector returns a collector of server, closed at the end of the
+// test.
+func newTestCollector(t *testing.T, server *eslmock.Server, password string, options Options) *Collector {
+	t.Helper()
+
+	c, err := New(server.URI(), 2*time.Second, password, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+// scrapeSamples returns the samples of a scrape of c, by series, e.g.
+// freeswitch_gateway_state{gateway="carrier1",state="REGED"}.
+func scrapeSamples(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+
+	families, err := registry.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return samples(families)
+}
+
+// samples returns the samples of families by series.
+func samples(families []*dto.MetricFamily) map[string]float64 {
+	series := make(map[string]float64)
+
+	for _, family := range families {
+		for _, m := range family.Metric {
+			var labels []string
+
+			for _, pair := range m.Label {
+				labels = append(labels, fmt.Sprintf("%s=%q", pair.GetName(), pair.GetValue()))
+			}
+
+			sort.Strings(labels)
+
+			name := family.GetName()
+
+			if len(labels) != 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+
+			switch {
+			case m.Gauge != nil:
+				series[name] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				series[name] = m.GetCounter().GetValue()
+			case m.Untyped != nil:
+				series[name] = m.GetUntyped().GetValue()
+			}
+		}
+	}
+
+	return series
+}
+
+// expect checks that the samples of series are the wanted ones.
+func expect(t *testing.T, series map[string]float64, want map[string]float64) {
+	t.Helper()
+
+	for name, value := range want {
+		got, ok := series[name]
+
+		if !ok {
+			t.Errorf("%s: missing", name)
+		} else if got != value {
+			t.Errorf("%s: got %v, want %v", name, got, value)
+		}
+	}
+}
+
+func TestScrape(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestCollector(t, server, "ClueCon", Options{})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		"freeswitch_up":                                   1,
+		"freeswitch_uptime_seconds":                       3723,
+		"freeswitch_sessions_total":                       53,
+		"freeswitch_current_sessions":                     3,
+		"freeswitch_current_sessions_peak":                6,
+		"freeswitch_current_sps":                          1,
+		"freeswitch_max_sessions":                         1000,
+		"freeswitch_current_idle_cpu":                     97.5,
+		`freeswitch_collector_success{collector="core"}`:  1,
+		`freeswitch_collector_success{collector="sofia"}`: 1,
+		"freeswitch_time_synced":                          1,
+	})
+}
+
+func TestAuthRejected(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestCollector(t, server, "secret", Options{})
+
+	series := scrapeSamples(t, c)
+
+	expect(t, series, map[string]float64{
+		"freeswitch_up":                      0,
+		"freeswitch_exporter_total_scrapes":  1,
+		"freeswitch_exporter_failed_scrapes": 1,
+	})
+
+	if _, ok := series["freeswitch_uptime_seconds"]; ok {
+		t.Error("freeswitch_uptime_seconds exported without authentication")
+	}
+
+	if commands := server.Commands(); len(commands) != 0 {
+		t.Errorf("got commands %q after a rejected password", commands)
+	}
+}
+
+func TestFallbackPasswords(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestCollector(t, server, "secret", Options{FallbackPasswords: []string{"ClueCon"}})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{"freeswitch_up": 1})
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := parseStatus([]byte(eslmock.Status))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := parseJSONStatus([]byte(eslmock.JSONStatus))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range want {
+		if status[name] != value {
+			t.Errorf("%s: got %v from the text status, %v from the JSON one", name, status[name], value)
+		}
+	}
+
+	if _, err := parseStatus([]byte("-ERR status Command not found!\n")); err == nil {
+		t.Error("no error parsing an error reply")
+	}
+}
+
+func TestStatusFallsBackToText(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond(`json {"command":"status","data":""}`, "-ERR json Command not found!\n")
+
+	c := newTestCollector(t, server, "ClueCon", Options{})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		"freeswitch_sessions_total":   53,
+		"freeswitch_current_sessions": 3,
+		"freeswitch_max_sessions":     1000,
+	})
+}
+
+func TestSofia(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		xmlStatus string
+	}{
+		{"xmlstatus", sofiaXMLStatus},
+		// releases without sofia xmlstatus
+		{"status", "Usage: sofia status|xmlstatus\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.Respond("sofia xmlstatus", test.xmlStatus)
+			server.Respond("sofia status", sofiaStatus)
+
+			c := newTestCollector(t, server, "ClueCon", Options{})
+
+			expect(t, scrapeSamples(t, c), map[string]float64{
+				`freeswitch_gateway_state{gateway="carrier1",state="REGED"}`:     1,
+				`freeswitch_gateway_state{gateway="carrier1",state="FAIL_WAIT"}`: 0,
+				`freeswitch_gateway_state{gateway="carrier2",state="FAIL_WAIT"}`: 1,
+				"freeswitch_sofia_profiles":                                      1,
+				"freeswitch_sofia_aliases":                                       1,
+				"freeswitch_sofia_gateways":                                      2,
+				`freeswitch_collector_success{collector="sofia"}`:                1,
+			})
+		})
+	}
+}
+
+func TestSofiaNotLoaded(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("sofia xmlstatus", "-ERR sofia Command not found!\n")
+	server.Respond("sofia status", "-ERR sofia Command not found!\n")
+
+	c := newTestCollector(t, server, "ClueCon", Options{})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		"freeswitch_sofia_profiles":                       0,
+		`freeswitch_collector_success{collector="sofia"}`: 1,
+	})
+}
+
+func TestProbeCall(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("originate {originate_timeout=1}loopback/9196 &hangup()", "+OK 5f2b8c9e\n")
+
+	c := newTestCollector(t, server, "ClueCon", Options{
+		ProbeCall:         "loopback/9196",
+		ProbeCallInterval: 50 * time.Millisecond,
+		ProbeCallTimeout:  time.Second,
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		series := scrapeSamples(t, c)
+
+		if series["freeswitch_probe_call_success"] == 1 {
+			if series["freeswitch_probe_call_setup_seconds"] <= 0 {
+				t.Errorf("freeswitch_probe_call_setup_seconds: got %v, want > 0", series["freeswitch_probe_call_setup_seconds"])
+			}
+
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatal("test call not answered")
+}
+
+func TestProbeCallFailed(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("originate {originate_timeout=1}loopback/9196 &hangup()", "-ERR NO_ANSWER\n")
+
+	c := newTestCollector(t, server, "ClueCon", Options{
+		ProbeCall:         "loopback/9196",
+		ProbeCallInterval: 50 * time.Millisecond,
+		ProbeCallTimeout:  time.Second,
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		series := scrapeSamples(t, c)
+
+		if value, ok := series["freeswitch_probe_call_success"]; ok {
+			if value != 0 {
+				t.Errorf("freeswitch_probe_call_success: got %v, want 0", value)
+			}
+
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatal("no test call")
+}
+
+func TestCalls(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("sofia xmlstatus", sofiaXMLStatus)
+	server.Respond("show calls as json", `{"row_count":4,"rows":[`+
+		`{"uuid":"a","name":"sofia/internal/1000@10.0.0.1","b_name":"sofia/external/33123456789@carrier1.com"},`+
+		`{"uuid":"b","name":"sofia/internal/1001@10.0.0.1","b_name":""},`+
+		`{"uuid":"c","name":"loopback/9196-a","b_name":"loopback/9196-b"},`+
+		`{"uuid":"d","name":"loopback/9197-a","b_name":"sofia/external/33987654321@carrier1.com"}]}`)
+
+	c := newTestCollector(t, server, "ClueCon", Options{})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		"freeswitch_current_calls_all":                 4,
+		`freeswitch_current_calls{profile="internal"}`: 2,
+		`freeswitch_current_calls{profile="external"}`: 1,
+		`freeswitch_current_calls{profile=""}`:         1,
+	})
+}
+
+func TestGatewayExpiry(t *testing.T) {
+	const expiry = `freeswitch_gateway_registration_expiry_seconds{gateway="carrier1"}`
+
+	for _, events := range []bool{false, true} {
+		t.Run(fmt.Sprintf("events=%v", events), func(t *testing.T) {
+			server := newTestServer(t)
+			server.Respond("sofia xmlstatus", sofiaXMLStatus)
+			server.Respond("sofia xmlstatus gateway carrier1", "<gateway>\n<name>carrier1</name>\n<expires>3600</expires>\n<state>REGED</state>\n</gateway>\n")
+
+			c := newTestCollector(t, server, "ClueCon", Options{Collectors: []string{"sofia"}, Events: events})
+
+			if events {
+				deadline := time.Now().Add(5 * time.Second)
+
+				// registered 600 seconds ago, once the event connection is up
+				for server.SendEvent(map[string]string{
+					"Event-Name":           "CUSTOM",
+					"Event-Subclass":       "sofia::gateway_state",
+					"Event-Date-Timestamp": fmt.Sprint(time.Now().Add(-600 * time.Second).UnixMicro()),
+					"Gateway":              "carrier1",
+					"State":                "REGED",
+				}) == 0 {
+					if time.Now().After(deadline) {
+						t.Fatal("no event connection")
+					}
+
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			// registered at the first scrape without events
+			want := 3600.0
+
+			if events {
+				want = 3000
+			}
+
+			value, ok := scrapeSamples(t, c)[expiry]
+
+			switch {
+			case !ok:
+				t.Errorf("%s: missing", expiry)
+			case value < want-10 || value > want:
+				t.Errorf("%s: got %v, want %v", expiry, value, want)
+			}
+		})
+	}
+}
+
+func TestGatewayExpiryRefreshed(t *testing.T) {
+	registered := time.Unix(1700000000, 0)
+
+	for _, test := range []struct {
+		elapsed       time.Duration
+		expires, freq float64
+		want          float64
+	}{
+		{600 * time.Second, 3600, 0, 3000},
+		{4200 * time.Second, 3600, 0, 3000},
+		{4200 * time.Second, 3600, 1800, 3000},
+		{1000 * time.Second, 3600, 1800, 2600},
+		{4000 * time.Second, 3600, 7200, 3200},
+	} {
+		if got := gatewayExpiry(registered, registered.Add(test.elapsed), test.expires, test.freq); got != test.want {
+			t.Errorf("%v elapsed, expires %v, freq %v: got %v, want %v", test.elapsed, test.expires, test.freq, got, test.want)
+		}
+	}
+}
+
+func TestTransfersFiltered(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestCollector(t, server, "ClueCon", Options{Collectors: []string{}, Events: true})
+
+	execute := func(application string) int {
+		return server.SendEvent(map[string]string{"Event-Name": "CHANNEL_EXECUTE", "Application": application})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for execute("att_xfer") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no event connection")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := execute("answer"); n != 0 {
+		t.Errorf("CHANNEL_EXECUTE of answer sent, want it filtered out")
+	}
+
+	for _, want := range []string{"filter Application att_xfer", "filter Event-Name CHANNEL_HANGUP_COMPLETE", "filter Event-Subclass sofia::transferor"} {
+		found := false
+
+		for _, command := range server.Commands() {
+			found = found || command == want
+		}
+
+		if !found {
+			t.Errorf("%q not sent", want)
+		}
+	}
+
+	for time.Now().Before(deadline) {
+		if scrapeSamples(t, c)[`freeswitch_transfers_total{type="attended"}`] == 1 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("att_xfer not counted")
+}
+
+func TestCallcenter(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("callcenter_config agent list", "name|instance_id|uuid|type|contact|status|state|max_no_answer\n"+
+		"1000@default|single_box||callback|user/1000|Available|Waiting|3\n"+
+		"1001@default|single_box||callback|user/1001|Available|In a queue call|3\n"+
+		"1002@default|single_box||callback|user/1002|Available|Waiting|3\n+OK\n")
+	server.Respond("callcenter_config queue list", "name|strategy|moh_sound\nsupport@default|longest-idle-agent|local_stream://moh\nsales@default|ring-all|local_stream://moh\n+OK\n")
+	server.Respond("callcenter_config queue list members support@default", "queue|instance_id|uuid|session_uuid|cid_number|cid_name|system_epoch|joined_epoch|rejoined_epoch|bridge_epoch|abandoned_epoch|base_score|skill_score|serving_agent|serving_system|state\n"+
+		"support@default|single_box|a|b|100|x|0|0|0|0|0|0|0|||Waiting\n"+
+		"support@default|single_box|c|d|101|y|0|0|0|0|0|0|0|1001@default|single_box|Answered\n+OK\n")
+	server.Respond("callcenter_config queue list members sales@default", "queue|instance_id|uuid|session_uuid|cid_number|cid_name|system_epoch|joined_epoch|rejoined_epoch|bridge_epoch|abandoned_epoch|base_score|skill_score|serving_agent|serving_system|state\n+OK\n")
+
+	c := newTestCollector(t, server, "ClueCon", Options{Collectors: []string{"callcenter"}})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		`freeswitch_callcenter_agents{state="Waiting",status="Available"}`:              2,
+		`freeswitch_callcenter_agents{state="In a queue call",status="Available"}`:      1,
+		`freeswitch_callcenter_queue_members{queue="support@default",state="Waiting"}`:  1,
+		`freeswitch_callcenter_queue_members{queue="support@default",state="Answered"}`: 1,
+		`freeswitch_callcenter_queue_members{queue="support@default",state="Trying"}`:   0,
+		`freeswitch_callcenter_queue_members{queue="sales@default",state="Waiting"}`:    0,
+		`freeswitch_collector_success{collector="callcenter"}`:                          1,
+	})
+}
+
+func TestHeartbeatStatusNotPolled(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestCollector(t, server, "ClueCon", Options{Collectors: []string{"status"}, Heartbeat: true})
+
+	heartbeat := map[string]string{
+		"Event-Name":              "HEARTBEAT",
+		"Uptime-msec":             "3723000",
+		"Session-Since-Startup":   "60",
+		"Session-Count":           "7",
+		"Session-Peak-Max":        "9",
+		"Session-Peak-FiveMin":    "8",
+		"Session-Per-Sec-Last":    "2",
+		"Session-Per-Sec-Max":     "5",
+		"Session-Per-Sec-FiveMin": "3",
+		"Max-Sessions":            "1000",
+		"Idle-CPU":                "95.5",
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for server.SendEvent(heartbeat) == 0 || c.lastHeartbeat() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no heartbeat received")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		expect(t, scrapeSamples(t, c), map[string]float64{
+			"freeswitch_current_sessions": 7,
+			"freeswitch_max_sps":          30,
+			"freeswitch_min_idle_cpu":     0,
+		})
+	}
+
+	polled := 0
+
+	for _, command := range server.Commands() {
+		if strings.HasPrefix(command, "api json ") || command == "api status" {
+			polled++
+		}
+	}
+
+	if polled != 1 {
+		t.Errorf("status polled %d times for 3 scrapes, want 1", polled)
+	}
+}
+
+func TestChannelVariablesFromChannels(t *testing.T) {
+	server := newTestServer(t)
+	server.Respond("show channels as json", `{"row_count":3,"rows":[`+
+		`{"uuid":"a","accountcode":"acme","read_rate":"8000"},`+
+		`{"uuid":"b","accountcode":"acme","read_rate":"48000"},`+
+		`{"uuid":"c","accountcode":"","read_rate":""}]}`)
+
+	c := newTestCollector(t, server, "ClueCon", Options{Collectors: []string{"channels"}, ChannelVariables: []ChannelVariable{
+		{Name: "account_channels", Type: "gauge", Source: "channels", Labels: map[string]string{"account": "accountcode"}},
+		{Name: "account_read_rate", Type: "gauge", Source: "channels", Variable: "read_rate", Labels: map[string]string{"account": "accountcode"}},
+	}})
+
+	expect(t, scrapeSamples(t, c), map[string]float64{
+		`freeswitch_account_channels{account="acme"}`:        2,
+		`freeswitch_account_channels{account=""}`:            1,
+		`freeswitch_account_read_rate{account="acme"}`:       56000,
+		`freeswitch_collector_success{collector="channels"}`: 1,
+	})
+
+	if _, err := newChannelVariableMetric(ChannelVariable{Name: "channels_total", Type: "counter", Source: "channels"}); err == nil {
+		t.Error("counter of the channels source accepted")
+	}
+}
@@ -0,0 +1,332 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// CommandMetric declares a metric read from the response of an api command.
+//
+// The response is parsed with one of Regex, JSON or Lines. With Regex, every
+// match is a sample whose value is the group named "value" (or the first
+// unnamed group), and whose labels are the other named groups, only the first
+// match of each set of labels being kept. JSON is the dotted path
+// of a number in the response (e.g. "rows.0.count"), and Lines counts the non
+// empty lines of the response. Labels are added to every sample. If CacheTTL
+// is set, the command is only sent again once the previous response is older.
+type CommandMetric struct {
+	Name    string            `yaml:"name"`
+	Help    string            `yaml:"help"`
+	Type    string            `yaml:"type"`
+	Command string            `yaml:"command"`
+	Regex   string            `yaml:"regex"`
+	JSON    string            `yaml:"json"`
+	Lines   bool              `yaml:"lines"`
+	Labels  map[string]string `yaml:"labels"`
+
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// commandMetric is a parsed CommandMetric.
+type commandMetric struct {
+	command   string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	parse     func(response []byte) ([]commandSample, error)
+
+	ttl     time.Duration
+	samples []commandSample
+	fetched time.Time
+}
+
+type commandSample struct {
+	value  float64
+	labels []string
+}
+
+// Validate checks that def can be exported.
+func (def CommandMetric) Validate() error {
+	_, err := newCommandMetric(def)
+	return err
+}
+
+func newCommandMetric(def CommandMetric) (*commandMetric, error) {
+	if !model.IsValidMetricName(model.LabelValue(Namespace + "_" + def.Name)) {
+		return nil, fmt.Errorf("command metric %q: invalid name", def.Name)
+	}
+
+	if len(strings.TrimSpace(def.Command)) == 0 {
+		return nil, fmt.Errorf("command metric %q: command is required", def.Name)
+	}
+
+	m := commandMetric{
+		command: "api " + strings.TrimSpace(def.Command),
+		ttl:     def.CacheTTL,
+	}
+
+	if m.ttl < 0 {
+		return nil, fmt.Errorf("command metric %q: negative cache_ttl", def.Name)
+	}
+
+	switch def.Type {
+	case "", "gauge":
+		m.valueType = prometheus.GaugeValue
+	case "counter":
+		m.valueType = prometheus.CounterValue
+	default:
+		return nil, fmt.Errorf("command metric %q: unknown type %q", def.Name, def.Type)
+	}
+
+	// labels of the regex groups, in group order
+	var groupLabels []string
+
+	parsers := 0
+
+	if len(def.Regex) != 0 {
+		parsers++
+
+		re, err := regexp.Compile(def.Regex)
+
+		if err != nil {
+			return nil, fmt.Errorf("command metric %q: %w", def.Name, err)
+		}
+
+		valueIndex := re.SubexpIndex("value")
+
+		for i, name := range re.SubexpNames() {
+			if i > 0 && valueIndex < 0 && len(name) == 0 {
+				valueIndex = i
+			}
+		}
+
+		if valueIndex < 0 {
+			return nil, fmt.Errorf("command metric %q: regex has no value group", def.Name)
+		}
+
+		var labelIndexes []int
+
+		for i, name := range re.SubexpNames() {
+			if i == valueIndex || len(name) == 0 {
+				continue
+			}
+
+			groupLabels = append(groupLabels, name)
+			labelIndexes = append(labelIndexes, i)
+		}
+
+		m.parse = func(response []byte) ([]commandSample, error) {
+			return parseRegexResponse(re, valueIndex, labelIndexes, response)
+		}
+	}
+
+	if len(def.JSON) != 0 {
+		parsers++
+
+		path := strings.Split(def.JSON, ".")
+
+		m.parse = func(response []byte) ([]commandSample, error) {
+			return parseJSONResponse(path, response)
+		}
+	}
+
+	if def.Lines {
+		parsers++
+
+		m.parse = parseLinesResponse
+	}
+
+	if parsers != 1 {
+		return nil, fmt.Errorf("command metric %q: exactly one of regex, json or lines is required", def.Name)
+	}
+
+	labelNames := append([]string{}, groupLabels...)
+
+	for label := range def.Labels {
+		labelNames = append(labelNames, label)
+	}
+
+	sort.Strings(labelNames)
+
+	for i, label := range labelNames {
+		if !model.LabelName(label).IsValid() {
+			return nil, fmt.Errorf("command metric %q: invalid label %q", def.Name, label)
+		}
+
+		if i > 0 && labelNames[i-1] == label {
+			return nil, fmt.Errorf("command metric %q: duplicate label %q", def.Name, label)
+		}
+	}
+
+	help := def.Help
+
+	if len(help) == 0 {
+		help = fmt.Sprintf("Value read from the response of %q", strings.TrimSpace(def.Command))
+	}
+
+	m.desc = prometheus.NewDesc(Namespace+"_"+def.Name, help, groupLabels, def.Labels)
+
+	return &m, nil
+}
+
+// scrapeCommands exports the metrics of Options.Commands.
+func (c *Collector) scrapeCommands(ch chan<- prometheus.Metric) error {
+	for _, m := range c.commandMetrics {
+		samples, err := m.fetch(c)
+
+		var reply errorReply
+
+		if errors.As(err, &reply) {
+			level.Debug(c.logger).Log("msg", "Command metric skipped after an error reply", "target", c.URI, "command", m.command, "err", err)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, sample := range samples {
+			metric, err := prometheus.NewConstMetric(m.desc, m.valueType, sample.value, sample.labels...)
+
+			if err != nil {
+				return err
+			}
+
+			ch <- metric
+		}
+	}
+
+	return nil
+}
+
+// fetch returns the samples of m, from the cache if they are recent enough.
+func (m *commandMetric) fetch(c *Collector) ([]commandSample, error) {
+	if m.ttl > 0 && time.Since(m.fetched) < m.ttl {
+		return m.samples, nil
+	}
+
+	response, err := c.fsCommand(m.command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(response, []byte("-ERR")) {
+		return nil, newErrorReply(m.command, response)
+	}
+
+	samples, err := m.parse(response)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.command, err)
+	}
+
+	m.samples = samples
+	m.fetched = time.Now()
+
+	return samples, nil
+}
+
+// parseRegexResponse returns the samples of the matches of re in response.
+// The matches with the labels of a previous match are skipped, as they would
+// be the same series.
+func parseRegexResponse(re *regexp.Regexp, valueIndex int, labelIndexes []int, response []byte) ([]commandSample, error) {
+	var samples []commandSample
+
+	seen := make(map[string]bool)
+
+	for _, match := range re.FindAllSubmatch(response, -1) {
+		var labels []string
+
+		for _, i := range labelIndexes {
+			labels = append(labels, string(match[i]))
+		}
+
+		key := strings.Join(labels, "\xff")
+
+		if seen[key] {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(string(match[valueIndex]), 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse value: %w", err)
+		}
+
+		seen[key] = true
+		samples = append(samples, commandSample{value: value, labels: labels})
+	}
+
+	return samples, nil
+}
+
+func parseJSONResponse(path []string, response []byte) ([]commandSample, error) {
+	var node interface{}
+
+	if err := json.Unmarshal(response, &node); err != nil {
+		return nil, fmt.Errorf("cannot read JSON response: %w", err)
+	}
+
+	for _, key := range path {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			node = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("no element %q in JSON list", key)
+			}
+
+			node = v[i]
+		default:
+			return nil, fmt.Errorf("no field %q in JSON response", key)
+		}
+	}
+
+	switch v := node.(type) {
+	case float64:
+		return []commandSample{{value: v}}, nil
+	case bool:
+		if v {
+			return []commandSample{{value: 1}}, nil
+		}
+
+		return []commandSample{{value: 0}}, nil
+	case string:
+		value, err := strconv.ParseFloat(v, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse value: %w", err)
+		}
+
+		return []commandSample{{value: value}}, nil
+	case nil:
+		return nil, errors.New("JSON field not found")
+	}
+
+	return nil, errors.New("JSON field is not a number")
+}
+
+func parseLinesResponse(response []byte) ([]commandSample, error) {
+	count := 0
+
+	for _, line := range bytes.Split(response, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) != 0 {
+			count++
+		}
+	}
+
+	return []commandSample{{value: float64(count)}}, nil
+}
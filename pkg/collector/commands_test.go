@@ -0,0 +1,45 @@
+package collector
+
+import "testing"
+
+const verto = `profile-1	profile	wss://10.0.0.1:8082	RUNNING (2)
+profile-2	profile	wss://10.0.0.1:8083	RUNNING (3)
+profile-1	profile	wss://10.0.0.2:8082	RUNNING (4)
+`
+
+func TestCommandMetrics(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		regex string
+		want  map[string]float64
+	}{
+		{
+			name:  "without label groups",
+			regex: `RUNNING \((\d+)\)`,
+			want:  map[string]float64{`freeswitch_verto_clients`: 2},
+		},
+		{
+			name:  "with label groups",
+			regex: `(?P<profile>\S+)\s+profile\s+\S+\s+RUNNING \((?P<value>\d+)\)`,
+			want: map[string]float64{
+				`freeswitch_verto_clients{profile="profile-1"}`: 2,
+				`freeswitch_verto_clients{profile="profile-2"}`: 3,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.Respond("verto status", verto)
+
+			c := newTestCollector(t, server, "ClueCon", Options{
+				Collectors: []string{"commands"},
+				Commands:   []CommandMetric{{Name: "verto_clients", Command: "verto status", Regex: test.regex}},
+			})
+
+			// duplicate series would fail the gather
+			series := scrapeSamples(t, c)
+			expect(t, series, test.want)
+			expect(t, series, map[string]float64{`freeswitch_collector_success{collector="commands"}`: 1})
+		})
+	}
+}
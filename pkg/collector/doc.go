@@ -0,0 +1,20 @@
+// Package collector exports the metrics of a FreeSWITCH instance, polled
+// through its event socket (mod_event_socket) and derived from its events.
+//
+// A Collector is a prometheus.Collector, so it can be registered alongside
+// other collectors:
+//
+//	c, err := collector.New("tcp://localhost:8021", 5*time.Second, "ClueCon", collector.Options{})
+//
+//	if err != nil {
+//		return err
+//	}
+//
+//	defer c.Close()
+//	prometheus.MustRegister(c)
+//
+// Every Collect scrapes FreeSWITCH, one scrape at a time. NewScrape returns a
+// collector bounded by a deadline, for a single scrape of some of the
+// collectors listed by Collectors. With Options.TracerProvider, scrapes and
+// their commands are traced, NewScrapeContext taking the parent span.
+package collector
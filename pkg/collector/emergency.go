@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// emergencyMetric counts the calls whose destination number matches one of
+// the configured patterns.
+type emergencyMetric struct {
+	patterns []*regexp.Regexp
+	calls    *prometheus.CounterVec
+}
+
+func newEmergencyMetric(patterns []string) (*emergencyMetric, error) {
+	m := emergencyMetric{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "emergency_calls_total",
+			Help:      "Number of inbound channels created with a destination number matching an emergency pattern, by pattern.",
+		}, []string{"pattern"}),
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("emergency pattern %q: %w", pattern, err)
+		}
+
+		m.patterns = append(m.patterns, re)
+
+		// export zero values so that rate() works from the first call
+		m.calls.WithLabelValues(pattern)
+	}
+
+	return &m, nil
+}
+
+// handleCreate counts a CHANNEL_CREATE event. Only inbound channels are
+// counted, so that bridged outbound legs do not count the same call twice.
+func (m *emergencyMetric) handleCreate(event Event) {
+	if event["Call-Direction"] != "inbound" {
+		return
+	}
+
+	destination := event["Caller-Destination-Number"]
+
+	for _, re := range m.patterns {
+		if re.MatchString(destination) {
+			m.calls.WithLabelValues(re.String()).Inc()
+		}
+	}
+}
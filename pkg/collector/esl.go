@@ -0,0 +1,331 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eslExitTimeout bounds the write of the exit command when closing a
+// connection.
+const eslExitTimeout = time.Second
+
+// defaultESLPort is the port of the event socket of URIs without a port.
+const defaultESLPort = "8021"
+
+// eslConn is an authenticated connection to the FreeSWITCH event socket.
+type eslConn struct {
+	conn  net.Conn
+	input *bufio.Reader
+
+	// commands may be pipelined: written is the number of commands written,
+	// and read the number of replies read, the reply of a command being read
+	// once read reaches its turn (see send and receive)
+	mutex   sync.Mutex
+	turn    *sync.Cond
+	written uint64
+	read    uint64
+
+	// broken is set when the connection cannot be used anymore
+	broken bool
+
+	// failover is set for connections to a failover URI, see dialURLs
+	failover bool
+
+	// how long connecting and authenticating took, for dialed connections
+	connectDuration time.Duration
+	authDuration    time.Duration
+}
+
+// errorReply is the -ERR reply of an api command, e.g. when the module of
+// the command is not loaded.
+type errorReply struct {
+	command string
+	reply   string
+}
+
+func (e errorReply) Error() string {
+	return fmt.Sprintf("%s: %s", e.command, e.reply)
+}
+
+// newErrorReply returns the errorReply of response to command.
+func newErrorReply(command string, response []byte) errorReply {
+	return errorReply{command, string(bytes.TrimSpace(response))}
+}
+
+// authRejected is the reply of the event socket rejecting the password,
+// after which it closes the connection.
+type authRejected struct {
+	reply string
+}
+
+func (e authRejected) Error() string {
+	return "auth failed: " + e.reply
+}
+
+// transientError is a connection error, after which a command can be sent
+// again on a new connection.
+type transientError struct {
+	error
+}
+
+func (e transientError) Unwrap() error {
+	return e.error
+}
+
+// dialer returns a new connection, established before deadline.
+type dialer func(deadline time.Time) (*eslConn, error)
+
+// dialESL connects to the event socket at u with d and authenticates with
+// password, as user if not empty. The connection deadline is set to timeout.
+func dialESL(d netDialer, u *url.URL, timeout time.Duration, user, password string) (*eslConn, error) {
+	address := u.Host
+
+	if u.Scheme == "unix" {
+		address = u.Path
+	} else if len(u.Port()) == 0 {
+		// IPv6 addresses are enclosed in brackets again
+		address = net.JoinHostPort(u.Hostname(), defaultESLPort)
+	}
+
+	start := time.Now()
+	conn, err := d.dial(u.Scheme, address, timeout)
+
+	if err != nil {
+		return nil, transientError{err}
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	e := newESLConn(conn)
+	e.connectDuration = time.Since(start)
+
+	if err = e.auth(user, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	e.authDuration = time.Since(start) - e.connectDuration
+
+	return e, nil
+}
+
+// newESLConn returns a connection on conn, which is not started.
+func newESLConn(conn net.Conn) *eslConn {
+	e := &eslConn{
+		conn:  conn,
+		input: bufio.NewReader(conn),
+	}
+
+	e.turn = sync.NewCond(&e.mutex)
+
+	return e
+}
+
+// Close closes the underlying connection.
+func (e *eslConn) Close() error {
+	return e.conn.Close()
+}
+
+// exit sends the exit command, so that FreeSWITCH does not log the
+// disconnection as unexpected, and closes the underlying connection.
+func (e *eslConn) exit() error {
+	e.conn.SetWriteDeadline(time.Now().Add(eslExitTimeout))
+	io.WriteString(e.conn, "exit\n\n")
+
+	return e.conn.Close()
+}
+
+// readMessage reads a message from the event socket, returning its headers
+// and its body (if any).
+func (e *eslConn) readMessage() (textproto.MIMEHeader, []byte, error) {
+	mimeReader := textproto.NewReader(e.input)
+	message, err := mimeReader.ReadMIMEHeader()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := message.Get("Content-Length")
+
+	if len(value) == 0 {
+		return message, nil, nil
+	}
+
+	length, _ := strconv.Atoi(value)
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(e.input, body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return message, body, nil
+}
+
+// connect starts an outbound connection, accepted from FreeSWITCH (socket
+// dialplan application), returning the channel data.
+func (e *eslConn) connect() (textproto.MIMEHeader, error) {
+	if _, err := io.WriteString(e.conn, "connect\n\n"); err != nil {
+		return nil, fmt.Errorf("cannot write connect: %w", err)
+	}
+
+	message, _, err := e.readMessage()
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read connect reply: %w", err)
+	}
+
+	if message.Get("Content-Type") != "command/reply" || strings.HasPrefix(message.Get("Reply-Text"), "-ERR") {
+		return nil, fmt.Errorf("connect failed: %s", message.Get("Reply-Text"))
+	}
+
+	return message, nil
+}
+
+// command sends command and returns the body of its reply. The connection is
+// marked as broken on I/O errors, or when FreeSWITCH disconnects.
+func (e *eslConn) command(command string) ([]byte, error) {
+	turn, err := e.send(command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.receive(turn)
+}
+
+// send writes command, returning the turn of its reply for receive. Commands
+// can be sent by several goroutines without waiting for the replies of the
+// previous ones, FreeSWITCH replying to api commands in order.
+func (e *eslConn) send(command string) (uint64, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.broken {
+		return 0, errors.New("cannot write command: broken connection")
+	}
+
+	if _, err := io.WriteString(e.conn, command+"\n\n"); err != nil {
+		e.broken = true
+		return 0, fmt.Errorf("cannot write command: %w", err)
+	}
+
+	e.written++
+
+	return e.written - 1, nil
+}
+
+// receive waits for the replies of the commands sent before turn to be read,
+// and returns the body of the reply of turn, or the Reply-Text of a command
+// reply (e.g. "+OK Job-UUID: ..." for bgapi).
+func (e *eslConn) receive(turn uint64) ([]byte, error) {
+	e.mutex.Lock()
+
+	for e.read != turn {
+		e.turn.Wait()
+	}
+
+	broken := e.broken
+	e.mutex.Unlock()
+
+	defer func() {
+		e.mutex.Lock()
+		e.read++
+		e.turn.Broadcast()
+		e.mutex.Unlock()
+	}()
+
+	// the reply of a previous command could not be read
+	if broken {
+		return nil, errors.New("cannot read command response: broken connection")
+	}
+
+	for {
+		message, body, err := e.readMessage()
+
+		if err != nil {
+			e.setBroken()
+			return nil, fmt.Errorf("cannot read command response: %w", err)
+		}
+
+		switch message.Get("Content-Type") {
+		case "api/response":
+			return body, nil
+		case "command/reply":
+			return []byte(message.Get("Reply-Text")), nil
+		case "text/disconnect-notice":
+			e.setBroken()
+			return nil, errors.New("cannot read command response: disconnected by FreeSWITCH")
+		}
+	}
+}
+
+// setBroken marks the connection as broken.
+func (e *eslConn) setBroken() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.broken = true
+}
+
+// isBroken tells if the connection cannot be used anymore.
+func (e *eslConn) isBroken() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.broken
+}
+
+func (e *eslConn) auth(user, password string) error {
+	mimeReader := textproto.NewReader(e.input)
+	message, err := mimeReader.ReadMIMEHeader()
+
+	if err != nil {
+		return fmt.Errorf("read auth failed: %w", err)
+	}
+
+	if message.Get("Content-Type") != "auth/request" {
+		return errors.New("auth failed: unknown content-type")
+	}
+
+	command := fmt.Sprintf("auth %s\n\n", password)
+
+	// users are declared in the directory, with esl-password and esl-allowed-*
+	// parameters
+	if len(user) != 0 {
+		command = fmt.Sprintf("userauth %s:%s\n\n", user, password)
+	}
+
+	_, err = io.WriteString(e.conn, command)
+
+	if err != nil {
+		return fmt.Errorf("write auth failed: %w", err)
+	}
+
+	message, err = mimeReader.ReadMIMEHeader()
+
+	if err != nil {
+		return fmt.Errorf("read auth failed: %w", err)
+	}
+
+	if message.Get("Content-Type") != "command/reply" {
+		return errors.New("auth failed: unknown reply")
+	}
+
+	if message.Get("Reply-Text") != "+OK accepted" {
+		return authRejected{message.Get("Reply-Text")}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package collector
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventMetrics are the metrics derived from the event stream.
+type eventMetrics struct {
+	mwi              *prometheus.CounterVec
+	registerAttempts *prometheus.CounterVec
+	authFailures     *prometheus.CounterVec
+	hangups          *prometheus.CounterVec
+	channelsCreated  *prometheus.CounterVec
+	mediaTimeouts    *prometheus.CounterVec
+	transfers        *prometheus.CounterVec
+	gatewayStates    *prometheus.CounterVec
+	callDuration     *prometheus.HistogramVec
+	callBillsec      *prometheus.HistogramVec
+
+	// exemplars attaches call UUIDs to the call metrics
+	exemplars bool
+}
+
+const (
+	// authFailureIPv4Prefix and authFailureIPv6Prefix are the prefix lengths of
+	// the source networks auth failures are counted by.
+	authFailureIPv4Prefix = 24
+	authFailureIPv6Prefix = 64
+)
+
+func newEventMetrics(exemplars bool) *eventMetrics {
+	return &eventMetrics{
+		exemplars: exemplars,
+		mwi: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "mwi_notifications_total",
+			Help:      "Number of message waiting notifications (MESSAGE_WAITING events), by domain.",
+		}, []string{"domain"}),
+		registerAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "sip_register_attempts_total",
+			Help:      "Number of SIP registration attempts (sofia::register_attempt events), by profile.",
+		}, []string{"profile"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "sip_auth_failures_total",
+			Help:      "Number of SIP authentication failures (sofia::register_failure events), by profile and source network.",
+		}, []string{"profile", "network"}),
+		hangups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "hangup_total",
+			Help:      "Number of channels hung up (CHANNEL_HANGUP_COMPLETE events), by gateway and hangup cause.",
+		}, []string{"gateway", "cause"}),
+		channelsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "channels_created_total",
+			Help:      "Number of channels created (CHANNEL_CREATE events), by direction.",
+		}, []string{"direction"}),
+		mediaTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "media_timeouts_total",
+			Help:      "Number of channels hung up because no media was received (MEDIA_TIMEOUT hangup cause), by gateway.",
+		}, []string{"gateway"}),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "transfers_total",
+			Help:      "Number of call transfers (sofia::transferor events and att_xfer executions), by type.",
+		}, []string{"type"}),
+		gatewayStates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "gateway_state_changes_total",
+			Help:      "Number of gateway registration state changes (sofia::gateway_state events), by gateway and new state.",
+		}, []string{"gateway", "state"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "call_duration_seconds",
+			Help:      "Duration of the channels hung up (CHANNEL_HANGUP_COMPLETE events), by gateway.",
+			Buckets:   DurationBuckets,
+		}, []string{"gateway"}),
+		callBillsec: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "call_billsec_seconds",
+			Help:      "Billed duration of the answered channels hung up (CHANNEL_HANGUP_COMPLETE events), by gateway.",
+			Buckets:   DurationBuckets,
+		}, []string{"gateway"}),
+	}
+}
+
+// register subscribes the metrics to the events they are derived from.
+func (m *eventMetrics) register(l *eventListener) {
+	l.handle("MESSAGE_WAITING", m.handleMessageWaiting)
+	l.handle("sofia::register_attempt", m.handleRegisterAttempt)
+	l.handle("sofia::register_failure", m.handleRegisterFailure)
+	l.handle("CHANNEL_HANGUP_COMPLETE", m.handleHangup)
+	l.handle("CHANNEL_CREATE", m.handleCreate)
+	l.handle("CHANNEL_EXECUTE", m.handleExecute)
+	l.filter("CHANNEL_EXECUTE", "Application", "att_xfer")
+	l.handle("sofia::transferor", m.handleTransferor)
+	l.handle("sofia::gateway_state", m.handleGatewayState)
+}
+
+// Describe implements prometheus.Collector.
+func (m *eventMetrics) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(m, ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *eventMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.mwi.Collect(ch)
+	m.registerAttempts.Collect(ch)
+	m.authFailures.Collect(ch)
+	m.hangups.Collect(ch)
+	m.channelsCreated.Collect(ch)
+	m.mediaTimeouts.Collect(ch)
+	m.transfers.Collect(ch)
+	m.gatewayStates.Collect(ch)
+	m.callDuration.Collect(ch)
+	m.callBillsec.Collect(ch)
+}
+
+func (m *eventMetrics) handleMessageWaiting(event Event) {
+	// MWI-Message-Account is either sip:user@domain or user@domain
+	account := strings.TrimPrefix(event["MWI-Message-Account"], "sip:")
+	domain := ""
+
+	if i := strings.LastIndex(account, "@"); i >= 0 {
+		domain = account[i+1:]
+	}
+
+	m.mwi.WithLabelValues(domain).Inc()
+}
+
+func (m *eventMetrics) handleRegisterAttempt(event Event) {
+	m.registerAttempts.WithLabelValues(event["profile-name"]).Inc()
+}
+
+func (m *eventMetrics) handleRegisterFailure(event Event) {
+	m.authFailures.WithLabelValues(event["profile-name"], sourceNetwork(event["network-ip"])).Inc()
+}
+
+func (m *eventMetrics) handleHangup(event Event) {
+	// sip_gateway_name is only set on legs that went through a gateway
+	gateway := event["variable_sip_gateway_name"]
+	cause := event["Hangup-Cause"]
+
+	exemplar := m.exemplar(event)
+	hangups := m.hangups.WithLabelValues(gateway, cause)
+
+	if exemplar != nil {
+		hangups.(prometheus.ExemplarAdder).AddWithExemplar(1, exemplar)
+	} else {
+		hangups.Inc()
+	}
+
+	// rtp-timeout-sec and rtp-hold-timeout-sec both hang up with MEDIA_TIMEOUT
+	if cause == "MEDIA_TIMEOUT" {
+		m.mediaTimeouts.WithLabelValues(gateway).Inc()
+	}
+
+	if duration, err := strconv.ParseFloat(event["variable_duration"], 64); err == nil {
+		observe(m.callDuration.WithLabelValues(gateway), duration, exemplar)
+	}
+
+	// answer_epoch is 0 for channels that were never answered
+	if event["variable_answer_epoch"] == "0" {
+		return
+	}
+
+	if billsec, err := strconv.ParseFloat(event["variable_billsec"], 64); err == nil {
+		observe(m.callBillsec.WithLabelValues(gateway), billsec, exemplar)
+	}
+}
+
+// exemplar returns the exemplar labels of the call metrics of event, or nil
+// if exemplars are disabled. Legs of the same call share their call_uuid.
+func (m *eventMetrics) exemplar(event Event) prometheus.Labels {
+	if !m.exemplars {
+		return nil
+	}
+
+	uuid := event["variable_call_uuid"]
+
+	if len(uuid) == 0 {
+		uuid = event["Unique-ID"]
+	}
+
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	return prometheus.Labels{"call_uuid": uuid}
+}
+
+// observe observes value with o, attaching exemplar if not nil.
+func observe(o prometheus.Observer, value float64, exemplar prometheus.Labels) {
+	if exemplar != nil {
+		o.(prometheus.ExemplarObserver).ObserveWithExemplar(value, exemplar)
+	} else {
+		o.Observe(value)
+	}
+}
+
+func (m *eventMetrics) handleCreate(event Event) {
+	m.channelsCreated.WithLabelValues(event["Call-Direction"]).Inc()
+}
+
+func (m *eventMetrics) handleGatewayState(event Event) {
+	m.gatewayStates.WithLabelValues(event["Gateway"], event["State"]).Inc()
+}
+
+// handleTransferor counts transfers requested with a SIP REFER, attended
+// transfers replacing an existing call.
+func (m *eventMetrics) handleTransferor(event Event) {
+	if len(event["att_xfer_replaced_call_id"]) != 0 {
+		m.transfers.WithLabelValues("attended").Inc()
+	} else {
+		m.transfers.WithLabelValues("blind").Inc()
+	}
+}
+
+// handleExecute counts attended transfers made from the dialplan.
+func (m *eventMetrics) handleExecute(event Event) {
+	if event["Application"] == "att_xfer" {
+		m.transfers.WithLabelValues("attended").Inc()
+	}
+}
+
+// sourceNetwork returns the network of ip in CIDR notation, or ip itself if
+// it cannot be parsed.
+func sourceNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return ip
+	}
+
+	mask := net.CIDRMask(authFailureIPv6Prefix, 128)
+
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4
+		mask = net.CIDRMask(authFailureIPv4Prefix, 32)
+	}
+
+	network := net.IPNet{IP: parsed.Mask(mask), Mask: mask}
+
+	return network.String()
+}
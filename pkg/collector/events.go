@@ -0,0 +1,428 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Event is a FreeSWITCH event received in plain format, mapping header names
+// to their (decoded) values. The body of the event, if any, is the value of
+// eventBody.
+type Event map[string]string
+
+// eventBody is the key of the body of an event, named like in the JSON events
+// of FreeSWITCH.
+const eventBody = "_body"
+
+type eventHandler func(Event)
+
+// syncHandler initializes state from the response of an api command, sent
+// when the event connection is established.
+type syncHandler struct {
+	command string
+	handle  func(response []byte) error
+}
+
+const (
+	eventReconnectDelay = 5 * time.Second
+
+	defaultEventBufferSize = 1000
+)
+
+var (
+	eventQueueLengthDesc   = prometheus.NewDesc(Namespace+"_exporter_event_queue_length", "Number of events received and waiting to be processed.", nil, nil)
+	eventQueueCapacityDesc = prometheus.NewDesc(Namespace+"_exporter_event_queue_capacity", "Number of events that can wait to be processed, the next ones being dropped.", nil, nil)
+)
+
+// eventListener keeps a dedicated event socket connection subscribed to
+// events, and dispatches them to handlers. Handlers are keyed by event name,
+// or by subclass for CUSTOM events. Events are read from the connection as
+// they come, and processed from a queue of bufferSize events, so that slow
+// handlers do not delay reading: events received while the queue is full are
+// dropped and counted.
+type eventListener struct {
+	url        *url.URL
+	timeout    time.Duration
+	dial       dialer
+	logger     log.Logger
+	bufferSize int
+
+	handlers map[string][]eventHandler
+	filters  map[string][]eventFilter
+	syncs    []syncHandler
+
+	processed prometheus.Counter
+	dropped   prometheus.Counter
+
+	mutex        sync.Mutex
+	disconnected []func()
+	esl          *eslConn
+	queue        chan Event
+	done         chan struct{}
+}
+
+func newEventListener(u *url.URL, timeout time.Duration, dial dialer, bufferSize int, logger log.Logger) *eventListener {
+	if bufferSize < 1 {
+		bufferSize = defaultEventBufferSize
+	}
+
+	return &eventListener{
+		url:        u,
+		timeout:    timeout,
+		dial:       dial,
+		logger:     logger,
+		bufferSize: bufferSize,
+		handlers:   make(map[string][]eventHandler),
+		filters:    make(map[string][]eventFilter),
+		done:       make(chan struct{}),
+		processed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "exporter_events_processed_total",
+			Help:      "Number of events processed.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "exporter_events_dropped_total",
+			Help:      "Number of events dropped as the event queue was full.",
+		}),
+	}
+}
+
+// handle registers h for the events named name. Names containing "::" are
+// treated as CUSTOM event subclasses.
+func (l *eventListener) handle(name string, h eventHandler) {
+	l.handlers[name] = append(l.handlers[name], h)
+}
+
+// eventFilter is a header value that events must have to be sent by
+// FreeSWITCH.
+type eventFilter struct {
+	header, value string
+}
+
+// filter restricts the events named name sent by FreeSWITCH to those whose
+// header is value (or one of the values of other filters of name), so that
+// frequent events are filtered out before reaching the queue. The other
+// handled events are still all sent.
+func (l *eventListener) filter(name, header, value string) {
+	l.filters[name] = append(l.filters[name], eventFilter{header: header, value: value})
+}
+
+// filterCommands returns the "filter" commands of the filters of l, none if
+// there are no filters. As FreeSWITCH sends the events matching any filter
+// once one is set, the handled events without filters are let through by
+// name.
+func (l *eventListener) filterCommands() []string {
+	if len(l.filters) == 0 {
+		return nil
+	}
+
+	var commands []string
+
+	for name := range l.handlers {
+		filters, ok := l.filters[name]
+
+		switch {
+		case ok:
+			for _, f := range filters {
+				commands = append(commands, fmt.Sprintf("filter %s %s", f.header, f.value))
+			}
+		case strings.Contains(name, "::"):
+			commands = append(commands, "filter Event-Subclass "+name)
+		default:
+			commands = append(commands, "filter Event-Name "+name)
+		}
+	}
+
+	sort.Strings(commands)
+
+	return commands
+}
+
+// onConnect registers h to be called with the response of the api command
+// once subscribed. Events received before the response are dispatched after
+// h returns, so their handlers must tolerate events already reflected in the
+// response.
+func (l *eventListener) onConnect(command string, h func(response []byte) error) {
+	l.syncs = append(l.syncs, syncHandler{command: command, handle: h})
+}
+
+// onDisconnect registers f to be called when the event connection is lost,
+// so that handlers can invalidate state derived from the event stream.
+func (l *eventListener) onDisconnect(f func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.disconnected = append(l.disconnected, f)
+}
+
+// run connects to FreeSWITCH and processes events until stop is called,
+// reconnecting when the connection is lost.
+func (l *eventListener) run() {
+	for {
+		err := l.listen()
+
+		l.mutex.Lock()
+		for _, f := range l.disconnected {
+			f()
+		}
+		l.esl = nil
+		l.mutex.Unlock()
+
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		level.Error(l.logger).Log("msg", "Event connection lost", "target", l.url.String(), "err", err)
+
+		select {
+		case <-l.done:
+			return
+		case <-time.After(eventReconnectDelay):
+		}
+	}
+}
+
+// stop closes the event connection and makes run return.
+func (l *eventListener) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	close(l.done)
+
+	if l.esl != nil {
+		l.esl.exit()
+	}
+}
+
+// subscription returns the "event plain" command subscribing to all handled
+// events.
+func (l *eventListener) subscription() string {
+	var names, subclasses []string
+
+	for name := range l.handlers {
+		if strings.Contains(name, "::") {
+			subclasses = append(subclasses, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+
+	if len(subclasses) > 0 {
+		names = append(names, "CUSTOM")
+		names = append(names, subclasses...)
+	}
+
+	return "event plain " + strings.Join(names, " ")
+}
+
+func (l *eventListener) listen() error {
+	esl, err := l.dial(time.Now().Add(l.timeout))
+
+	if err != nil {
+		return err
+	}
+
+	defer esl.Close()
+
+	l.mutex.Lock()
+	select {
+	case <-l.done:
+		l.mutex.Unlock()
+		return errors.New("listener stopped")
+	default:
+		l.esl = esl
+	}
+	l.mutex.Unlock()
+
+	// filters are set first, so that no filtered out event is queued
+	for _, command := range l.filterCommands() {
+		if _, err = esl.command(command); err != nil {
+			return err
+		}
+	}
+
+	if _, err = esl.command(l.subscription()); err != nil {
+		return err
+	}
+
+	for _, h := range l.syncs {
+		if _, err = fmt.Fprintf(esl.conn, "%s\n\n", h.command); err != nil {
+			return fmt.Errorf("cannot write command: %w", err)
+		}
+	}
+
+	// events may not come for a while, the connection stays open until
+	// FreeSWITCH closes it
+	esl.conn.SetDeadline(time.Time{})
+
+	// the events queued are processed before the disconnect handlers run
+	queue := make(chan Event, l.bufferSize)
+	processed := make(chan struct{})
+
+	go func() {
+		defer close(processed)
+
+		for event := range queue {
+			l.dispatch(event)
+			l.processed.Inc()
+		}
+	}()
+
+	l.mutex.Lock()
+	l.queue = queue
+	l.mutex.Unlock()
+
+	defer func() {
+		close(queue)
+		<-processed
+	}()
+
+	// events are held until all api responses are handled
+	var pending []Event
+	synced := 0
+
+	for {
+		message, body, err := esl.readMessage()
+
+		if err != nil {
+			return fmt.Errorf("cannot read event: %w", err)
+		}
+
+		switch message.Get("Content-Type") {
+		case "text/event-plain":
+		case "api/response":
+			if synced == len(l.syncs) {
+				continue
+			}
+
+			if err = l.syncs[synced].handle(body); err != nil {
+				return err
+			}
+
+			if synced++; synced == len(l.syncs) {
+				for _, event := range pending {
+					l.enqueue(queue, event)
+				}
+
+				pending = nil
+			}
+
+			continue
+		case "text/disconnect-notice":
+			return errors.New("disconnected by FreeSWITCH")
+		default:
+			continue
+		}
+
+		event, err := parsePlainEvent(body)
+
+		if err != nil {
+			return err
+		}
+
+		if synced < len(l.syncs) {
+			pending = append(pending, event)
+			continue
+		}
+
+		l.enqueue(queue, event)
+	}
+}
+
+// enqueue queues event to be processed, or drops it if queue is full.
+func (l *eventListener) enqueue(queue chan<- Event, event Event) {
+	select {
+	case queue <- event:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (l *eventListener) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventQueueLengthDesc
+	ch <- eventQueueCapacityDesc
+	l.processed.Describe(ch)
+	l.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *eventListener) Collect(ch chan<- prometheus.Metric) {
+	l.mutex.Lock()
+	length := len(l.queue)
+	l.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(eventQueueLengthDesc, prometheus.GaugeValue, float64(length))
+	ch <- prometheus.MustNewConstMetric(eventQueueCapacityDesc, prometheus.GaugeValue, float64(l.bufferSize))
+	l.processed.Collect(ch)
+	l.dropped.Collect(ch)
+}
+
+func (l *eventListener) dispatch(event Event) {
+	name := event["Event-Name"]
+
+	if name == "CUSTOM" {
+		name = event["Event-Subclass"]
+	}
+
+	for _, h := range l.handlers[name] {
+		h(event)
+	}
+}
+
+// parsePlainEvent parses the body of a "text/event-plain" message. Header
+// names are kept as sent by FreeSWITCH, values are URL-decoded.
+func parsePlainEvent(body []byte) (Event, error) {
+	event := make(Event)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(line) == 0 {
+			// the body follows, e.g. the result of a background job
+			if len(event["Content-Length"]) != 0 {
+				_, content, _ := bytes.Cut(body, []byte("\n\n"))
+				event[eventBody] = string(content)
+			}
+
+			break
+		}
+
+		name, raw, found := strings.Cut(line, ":")
+
+		if !found {
+			return nil, fmt.Errorf("cannot parse event header: %q", line)
+		}
+
+		raw = strings.TrimPrefix(raw, " ")
+		value, err := url.PathUnescape(raw)
+
+		if err != nil {
+			value = raw
+		}
+
+		event[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot parse event: %w", err)
+	}
+
+	return event, nil
+}
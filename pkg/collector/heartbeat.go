@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// handleHeartbeat keeps the last HEARTBEAT event received.
+func (c *Collector) handleHeartbeat(event Event) {
+	c.heartbeatMutex.Lock()
+	defer c.heartbeatMutex.Unlock()
+
+	c.heartbeat = event
+}
+
+// clearHeartbeat forgets the last HEARTBEAT event, metrics will be polled
+// again until a new one is received.
+func (c *Collector) clearHeartbeat() {
+	c.heartbeatMutex.Lock()
+	defer c.heartbeatMutex.Unlock()
+
+	c.heartbeat = nil
+	c.heartbeatStatus = nil
+}
+
+// lastHeartbeat returns the last HEARTBEAT event received, or nil.
+func (c *Collector) lastHeartbeat() Event {
+	c.heartbeatMutex.Lock()
+	defer c.heartbeatMutex.Unlock()
+
+	return c.heartbeat
+}
+
+// lastHeartbeatStatus returns the status kept while HEARTBEAT events are
+// received, or nil if it was not polled since the event connection is up.
+func (c *Collector) lastHeartbeatStatus() map[string]float64 {
+	c.heartbeatMutex.Lock()
+	defer c.heartbeatMutex.Unlock()
+
+	return c.heartbeatStatus
+}
+
+// keepHeartbeatStatus keeps status for the next scrapes, until the event
+// connection is lost. Only its metrics without a Header are still read from
+// it, which are limits of the configuration.
+func (c *Collector) keepHeartbeatStatus(status map[string]float64) {
+	c.heartbeatMutex.Lock()
+	defer c.heartbeatMutex.Unlock()
+
+	if c.heartbeat != nil {
+		c.heartbeatStatus = status
+	}
+}
+
+// heartbeatValue returns the value of the metric from the HEARTBEAT event,
+// and the date of the event (zero if unknown).
+func heartbeatValue(metricDef *Metric, event Event) (float64, time.Time, error) {
+	value, err := strconv.ParseFloat(event[metricDef.Header], 64)
+
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("error parsing heartbeat: %w", err)
+	}
+
+	if metricDef.Name == "uptime_seconds" {
+		// Uptime-msec
+		value /= 1000
+	}
+
+	usec, err := strconv.ParseInt(event["Event-Date-Timestamp"], 10, 64)
+
+	if err != nil {
+		return value, time.Time{}, nil
+	}
+
+	return value, time.UnixMicro(usec), nil
+}
@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lowMOSMetric counts the channels whose inbound audio MOS is under a
+// threshold, a cheaper alternative to MOS histograms per gateway.
+type lowMOSMetric struct {
+	threshold float64
+	calls     *prometheus.CounterVec
+}
+
+func newLowMOSMetric(threshold float64) *lowMOSMetric {
+	return &lowMOSMetric{
+		threshold: threshold,
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "low_mos_calls_total",
+			Help:      "Number of channels hung up with an inbound audio MOS under the low MOS threshold, by gateway.",
+		}, []string{"gateway"}),
+	}
+}
+
+// handleHangup counts a CHANNEL_HANGUP_COMPLETE event. Channels without RTP
+// statistics (e.g. not answered) are ignored.
+func (m *lowMOSMetric) handleHangup(event Event) {
+	mos, err := strconv.ParseFloat(event["variable_rtp_audio_in_mos"], 64)
+
+	if err != nil || mos >= m.threshold {
+		return
+	}
+
+	m.calls.WithLabelValues(event["variable_sip_gateway_name"]).Inc()
+}
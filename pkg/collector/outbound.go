@@ -0,0 +1,275 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// OutboundServer accepts the outbound event socket connections of FreeSWITCH
+// instances (socket dialplan application), for when the exporter cannot
+// connect to them. Instances are identified by their switchname, and scraped
+// with a Collector each once they connected.
+type OutboundServer struct {
+	listener net.Listener
+	timeout  time.Duration
+	options  Options
+	logger   log.Logger
+
+	networks     []*net.IPNet
+	maxInstances int
+	idleTimeout  time.Duration
+
+	mutex sync.Mutex
+	nodes map[string]*outboundNode
+}
+
+// outboundNode is a FreeSWITCH instance that connected to an OutboundServer.
+type outboundNode struct {
+	collector *Collector
+
+	// accepted connections, waiting to be used by the collector
+	conns chan *eslConn
+
+	mutex sync.Mutex
+	// when the collector first waited in vain for a connection, zero if the
+	// instance connected since
+	idleSince time.Time
+}
+
+// NewOutboundServer listens on address for outbound connections. Only the
+// connections from networks are accepted (all if empty), of up to
+// maxInstances instances (unlimited if 0). Instances that kept the collector
+// without a connection for idleTimeout are removed (never if 0). The
+// collectors of the instances that connect are created with timeout and
+// options.
+func NewOutboundServer(address string, networks []*net.IPNet, maxInstances int, idleTimeout, timeout time.Duration, options Options) (*OutboundServer, error) {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &OutboundServer{
+		listener:     listener,
+		timeout:      timeout,
+		options:      options,
+		logger:       options.Logger,
+		networks:     networks,
+		maxInstances: maxInstances,
+		idleTimeout:  idleTimeout,
+		nodes:        make(map[string]*outboundNode),
+	}
+
+	if s.logger == nil {
+		s.logger = log.NewNopLogger()
+	}
+
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *OutboundServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			level.Error(s.logger).Log("msg", "Cannot accept outbound connection", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// handle starts the outbound connection conn, and hands it to the collector
+// of its instance.
+func (s *OutboundServer) handle(conn net.Conn) {
+	if !s.allowed(conn.RemoteAddr()) {
+		level.Debug(s.logger).Log("msg", "Rejected outbound connection from an address outside the allowed networks", "address", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	e := newESLConn(conn)
+
+	channel, err := e.connect()
+
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "Cannot start outbound connection", "address", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
+	}
+
+	name := channel.Get("FreeSWITCH-Switchname")
+
+	if len(name) == 0 {
+		name, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+
+	node, err := s.node(name)
+
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "Cannot accept outbound instance", "target", name, "address", conn.RemoteAddr(), "err", err)
+		e.exit()
+		return
+	}
+
+	select {
+	case node.conns <- e:
+		level.Debug(s.logger).Log("msg", "Outbound connection accepted", "target", name, "address", conn.RemoteAddr())
+	default:
+		level.Warn(s.logger).Log("msg", "Too many outbound connections, closing", "target", name, "address", conn.RemoteAddr())
+		e.exit()
+	}
+}
+
+// allowed returns whether addr is in the allowed networks of s, or true if
+// there are none.
+func (s *OutboundServer) allowed(addr net.Addr) bool {
+	if len(s.networks) == 0 {
+		return true
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+
+	if !ok {
+		return false
+	}
+
+	for _, network := range s.networks {
+		if network.Contains(tcp.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// node returns the instance named name, which is connecting, creating its
+// collector on its first connection.
+func (s *OutboundServer) node(name string) (*outboundNode, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.removeIdle()
+
+	if node, ok := s.nodes[name]; ok {
+		node.mutex.Lock()
+		node.idleSince = time.Time{}
+		node.mutex.Unlock()
+
+		return node, nil
+	}
+
+	if s.maxInstances > 0 && len(s.nodes) >= s.maxInstances {
+		return nil, fmt.Errorf("too many outbound instances, up to %d", s.maxInstances)
+	}
+
+	node := &outboundNode{conns: make(chan *eslConn, s.options.MaxConnections+1)}
+	c, err := newCollector("outbound://"+name, s.timeout, "", s.options, node.dial)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.collector = c
+	s.nodes[name] = node
+
+	return node, nil
+}
+
+// removeIdle removes the instances idle for longer than the idle timeout of
+// s, closing their collectors. The mutex of s must be held.
+func (s *OutboundServer) removeIdle() {
+	if s.idleTimeout <= 0 {
+		return
+	}
+
+	for name, node := range s.nodes {
+		node.mutex.Lock()
+		idle := !node.idleSince.IsZero() && time.Since(node.idleSince) > s.idleTimeout
+		node.mutex.Unlock()
+
+		if !idle {
+			continue
+		}
+
+		level.Info(s.logger).Log("msg", "Removing outbound instance without connection", "target", name, "idle_timeout", s.idleTimeout)
+
+		node.close()
+		delete(s.nodes, name)
+	}
+}
+
+// dial waits for a connection of the instance until deadline.
+func (n *outboundNode) dial(deadline time.Time) (*eslConn, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case e := <-n.conns:
+		return e, nil
+	case <-timer.C:
+		n.mutex.Lock()
+
+		if n.idleSince.IsZero() {
+			n.idleSince = time.Now()
+		}
+
+		n.mutex.Unlock()
+
+		return nil, errors.New("timed out waiting for an outbound connection")
+	}
+}
+
+// close closes the collector of n and its waiting connections.
+func (n *outboundNode) close() {
+	n.collector.Close()
+
+	for len(n.conns) > 0 {
+		(<-n.conns).exit()
+	}
+}
+
+// Collectors returns the collectors of the instances that connected to s, by
+// switchname.
+func (s *OutboundServer) Collectors() map[string]*Collector {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.removeIdle()
+
+	collectors := make(map[string]*Collector, len(s.nodes))
+
+	for name, node := range s.nodes {
+		collectors[name] = node.collector
+	}
+
+	return collectors
+}
+
+// Close stops listening, and closes the collectors and their connections.
+func (s *OutboundServer) Close() error {
+	err := s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, node := range s.nodes {
+		node.close()
+	}
+
+	return err
+}
@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestOutboundServer(t *testing.T, networks []*net.IPNet, maxInstances int, idleTimeout time.Duration) *OutboundServer {
+	t.Helper()
+
+	s, err := NewOutboundServer("127.0.0.1:0", networks, maxInstances, idleTimeout, time.Second, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// connectOutbound connects to s as the FreeSWITCH named name, and returns the
+// connection once started, or nil if s closed it.
+func connectOutbound(t *testing.T, s *OutboundServer, name string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	reader := bufio.NewReader(conn)
+
+	if command, err := reader.ReadString('\n'); err != nil || command != "connect\n" {
+		return nil
+	}
+
+	reader.ReadString('\n')
+	fmt.Fprintf(conn, "Content-Type: command/reply\nReply-Text: +OK\nFreeSWITCH-Switchname: %s\n\n", name)
+
+	// the connection of a rejected instance is exited
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	if _, err := reader.ReadString('\n'); err == nil || err == io.EOF {
+		return nil
+	}
+
+	return conn
+}
+
+func instances(s *OutboundServer) []string {
+	var names []string
+
+	for name := range s.Collectors() {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func TestOutboundAllowedNetworks(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	s := newTestOutboundServer(t, []*net.IPNet{network}, 0, 0)
+
+	if conn := connectOutbound(t, s, "edge1"); conn != nil {
+		t.Error("connection from 127.0.0.1 accepted")
+	}
+
+	if names := instances(s); len(names) != 0 {
+		t.Errorf("got instances %q, want none", names)
+	}
+}
+
+func TestOutboundMaxInstances(t *testing.T) {
+	s := newTestOutboundServer(t, nil, 1, 0)
+
+	if conn := connectOutbound(t, s, "edge1"); conn == nil {
+		t.Fatal("edge1 rejected")
+	}
+
+	if conn := connectOutbound(t, s, "edge2"); conn != nil {
+		t.Error("edge2 accepted over the limit")
+	}
+
+	if names := instances(s); len(names) != 1 || names[0] != "edge1" {
+		t.Errorf("got instances %q, want edge1", names)
+	}
+}
+
+func TestOutboundIdleTimeout(t *testing.T) {
+	s := newTestOutboundServer(t, nil, 1, 50*time.Millisecond)
+
+	if conn := connectOutbound(t, s, "edge1"); conn == nil {
+		t.Fatal("edge1 rejected")
+	}
+
+	s.mutex.Lock()
+	node := s.nodes["edge1"]
+	s.mutex.Unlock()
+
+	// the collector takes the connection, and then waits for another
+	if _, err := node.dial(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.dial(time.Now().Add(10 * time.Millisecond)); err == nil {
+		t.Fatal("got a second connection")
+	}
+
+	if names := instances(s); len(names) != 1 {
+		t.Errorf("got instances %q before the idle timeout, want edge1", names)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if names := instances(s); len(names) != 0 {
+		t.Errorf("got instances %q after the idle timeout, want none", names)
+	}
+
+	// the slot of the removed instance is free
+	if conn := connectOutbound(t, s, "edge2"); conn == nil {
+		t.Error("edge2 rejected")
+	}
+}
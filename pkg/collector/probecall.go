@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultProbeCallInterval = time.Minute
+	defaultProbeCallTimeout  = 30 * time.Second
+
+	// probeCallMargin is how long the result of a test call is waited for
+	// after its originate timeout, before the call is considered failed
+	probeCallMargin = 5 * time.Second
+)
+
+var (
+	probeCallSuccessDesc = prometheus.NewDesc(Namespace+"_probe_call_success", "Was the last test call answered.", nil, nil)
+	probeCallSetupDesc   = prometheus.NewDesc(Namespace+"_probe_call_setup_seconds", "Setup duration of the last answered test call, from originate to answer.", nil, nil)
+)
+
+// callProber originates a test call on an interval with bgapi originate,
+// hung up as soon as it is answered, and exports whether it was answered and
+// how long it took. The result is read from the BACKGROUND_JOB events of the
+// event listener, the job being sent on a connection of its own.
+type callProber struct {
+	dial       dialer
+	dialString string
+	interval   time.Duration
+	timeout    time.Duration
+	logger     log.Logger
+
+	mutex   sync.Mutex
+	pending map[string]chan string // Job-UUID to job result
+	probed  bool
+	success bool
+	setup   time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newProbeCallOptions returns the prober of the ProbeCall options.
+func newProbeCallOptions(dial dialer, options Options, logger log.Logger) (*callProber, error) {
+	interval, timeout := options.ProbeCallInterval, options.ProbeCallTimeout
+
+	if interval == 0 {
+		interval = defaultProbeCallInterval
+	}
+
+	if timeout == 0 {
+		timeout = defaultProbeCallTimeout
+	}
+
+	return newCallProber(dial, options.ProbeCall, interval, timeout, logger)
+}
+
+func newCallProber(dial dialer, dialString string, interval, timeout time.Duration, logger log.Logger) (*callProber, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("probe call interval %v: must be positive", interval)
+	}
+
+	if timeout < time.Second {
+		return nil, fmt.Errorf("probe call timeout %v: must be at least one second", timeout)
+	}
+
+	return &callProber{
+		dial:       dial,
+		dialString: dialString,
+		interval:   interval,
+		timeout:    timeout,
+		logger:     logger,
+		pending:    make(map[string]chan string),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// register subscribes the prober to the results of background jobs.
+func (p *callProber) register(l *eventListener) {
+	l.handle("BACKGROUND_JOB", p.handleJob)
+}
+
+func (p *callProber) handleJob(event Event) {
+	p.mutex.Lock()
+	result, ok := p.pending[event["Job-UUID"]]
+	p.mutex.Unlock()
+
+	if ok {
+		result <- event[eventBody]
+	}
+}
+
+// start probes on every interval, the first probe being one interval after
+// start so that the event listener is subscribed by then.
+func (p *callProber) start() {
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+
+			p.probe()
+		}
+	}()
+}
+
+func (p *callProber) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// originate returns the originate command of the test call of job, bounded
+// by the timeout of p and hung up once answered.
+func (p *callProber) originate(job string) string {
+	variables := fmt.Sprintf("originate_timeout=%d", int(p.timeout/time.Second))
+	dialString := "{" + variables + "}" + p.dialString
+
+	// variables of the dial string are kept
+	if strings.HasPrefix(p.dialString, "{") {
+		dialString = "{" + variables + "," + p.dialString[1:]
+	}
+
+	return fmt.Sprintf("bgapi originate %s &hangup()\nJob-UUID: %s", dialString, job)
+}
+
+// probe originates a test call and waits for its result.
+func (p *callProber) probe() {
+	job, err := newUUID()
+
+	if err != nil {
+		level.Error(p.logger).Log("msg", "Cannot originate test call", "err", err)
+		return
+	}
+
+	result := make(chan string, 1)
+
+	p.mutex.Lock()
+	p.pending[job] = result
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		delete(p.pending, job)
+		p.mutex.Unlock()
+	}()
+
+	start := time.Now()
+	err = p.send(job, start.Add(p.timeout))
+
+	if err == nil {
+		timer := time.NewTimer(p.timeout + probeCallMargin)
+		defer timer.Stop()
+
+		select {
+		case <-p.done:
+			return
+		case <-timer.C:
+			err = errors.New("timed out waiting for the result of originate")
+		case reply := <-result:
+			// +OK <uuid>, or -ERR <hangup cause>
+			if !strings.HasPrefix(reply, "+OK") {
+				err = fmt.Errorf("originate: %s", strings.TrimSpace(reply))
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.probed = true
+	p.success = err == nil
+
+	if err != nil {
+		level.Warn(p.logger).Log("msg", "Test call failed", "dial_string", p.dialString, "err", err)
+		return
+	}
+
+	p.setup = time.Since(start)
+}
+
+// send sends the originate job on a new connection established before
+// deadline.
+func (p *callProber) send(job string, deadline time.Time) error {
+	esl, err := p.dial(deadline)
+
+	if err != nil {
+		return err
+	}
+
+	defer esl.exit()
+
+	reply, err := esl.command(p.originate(job))
+
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(string(reply), "+OK") {
+		return fmt.Errorf("bgapi: %s", reply)
+	}
+
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (p *callProber) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeCallSuccessDesc
+	ch <- probeCallSetupDesc
+}
+
+// Collect implements prometheus.Collector. Nothing is exported before the
+// first test call, and the setup duration once a call was answered.
+func (p *callProber) Collect(ch chan<- prometheus.Metric) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.probed {
+		return
+	}
+
+	success := 0.0
+
+	if p.success {
+		success = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(probeCallSuccessDesc, prometheus.GaugeValue, success)
+
+	if p.setup > 0 {
+		ch <- prometheus.MustNewConstMetric(probeCallSetupDesc, prometheus.GaugeValue, p.setup.Seconds())
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,245 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// netDialer establishes the connections to the event socket, directly or
+// through a proxy.
+type netDialer interface {
+	dial(network, address string, timeout time.Duration) (net.Conn, error)
+	Close() error
+}
+
+// newNetDialer returns the dialer of options.Proxy, or a direct one if it is
+// empty.
+func newNetDialer(options Options) (netDialer, error) {
+	if len(options.Proxy) == 0 {
+		return directDialer{}, nil
+	}
+
+	u, err := url.Parse(options.Proxy)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return newSOCKSDialer(u)
+	case "ssh":
+		return newSSHDialer(u, options.SSHKeyFile, options.SSHKnownHostsFile)
+	}
+
+	return nil, fmt.Errorf("proxy URL %q: socks5 or ssh is expected", options.Proxy)
+}
+
+// directDialer connects to the event socket directly.
+type directDialer struct{}
+
+func (directDialer) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, address, timeout)
+}
+
+func (directDialer) Close() error {
+	return nil
+}
+
+// socksDialer connects to the event socket through a SOCKS5 proxy.
+type socksDialer struct {
+	dialer proxy.ContextDialer
+}
+
+func newSOCKSDialer(u *url.URL) (*socksDialer, error) {
+	var auth *proxy.Auth
+
+	if u.User != nil {
+		auth = &proxy.Auth{User: u.User.Username()}
+		auth.Password, _ = u.User.Password()
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &socksDialer{dialer: dialer.(proxy.ContextDialer)}, nil
+}
+
+func (d *socksDialer) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("cannot connect to a %s event socket through a SOCKS5 proxy", network)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.dialer.DialContext(ctx, network, address)
+}
+
+func (d *socksDialer) Close() error {
+	return nil
+}
+
+// sshDialer connects to the event socket through an SSH jump host, from
+// which it can be reached on the loopback interface or as a unix socket. The
+// SSH connection is shared by the connections to the event socket, and
+// established again once broken.
+type sshDialer struct {
+	address string
+	config  *ssh.ClientConfig
+
+	mutex  sync.Mutex
+	client *ssh.Client
+}
+
+func newSSHDialer(u *url.URL, keyFile, knownHostsFile string) (*sshDialer, error) {
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, errors.New("ssh proxy: user missing, e.g. ssh://exporter@jump.example.com")
+	}
+
+	if len(knownHostsFile) == 0 {
+		return nil, errors.New("ssh proxy: a known hosts file is required to check the host key")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("ssh proxy: %w", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            u.User.Username(),
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	if len(keyFile) != 0 {
+		key, err := os.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("ssh proxy: cannot read key: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+
+		if err != nil {
+			return nil, fmt.Errorf("ssh proxy: cannot parse key: %w", err)
+		}
+
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	if password, ok := u.User.Password(); ok {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+
+	if len(config.Auth) == 0 {
+		return nil, errors.New("ssh proxy: a key file or a password is required")
+	}
+
+	address := u.Host
+
+	if len(u.Port()) == 0 {
+		address = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	return &sshDialer{address: address, config: config}, nil
+}
+
+func (d *sshDialer) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for {
+		fresh := d.client == nil
+
+		if fresh {
+			config := *d.config
+			config.Timeout = time.Until(deadline)
+
+			client, err := ssh.Dial("tcp", d.address, &config)
+
+			if err != nil {
+				return nil, fmt.Errorf("ssh proxy: %w", err)
+			}
+
+			d.client = client
+		}
+
+		conn, err := d.dialClient(network, address, time.Until(deadline))
+
+		// a client that broke is only noticed when dialing through it
+		if err == nil || fresh || time.Until(deadline) <= 0 {
+			return conn, err
+		}
+
+		d.client.Close()
+		d.client = nil
+	}
+}
+
+// dialClient dials address through the client of d within timeout, as the
+// SSH client has no dial timeout.
+func (d *sshDialer) dialClient(network, address string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	client := d.client
+	done := make(chan result, 1)
+
+	go func() {
+		conn, err := client.Dial(network, address)
+		done <- result{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("ssh proxy: %w", r.err)
+		}
+
+		return r.conn, nil
+	case <-timer.C:
+		// closed once established
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+
+		return nil, fmt.Errorf("ssh proxy: timed out connecting to %s", address)
+	}
+}
+
+func (d *sshDialer) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.client == nil {
+		return nil
+	}
+
+	err := d.client.Close()
+	d.client = nil
+
+	return err
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"sort"
+	"time"
+)
+
+// Response is the raw response of an api command, recorded if
+// Options.RecordResponses is set.
+type Response struct {
+	Command  string
+	Sent     time.Time
+	Duration time.Duration
+	Body     []byte
+	Err      error
+}
+
+// record keeps r as the last response of its command.
+func (c *Collector) record(r Response) {
+	c.responsesMutex.Lock()
+	defer c.responsesMutex.Unlock()
+
+	c.responses[r.Command] = r
+}
+
+// Responses returns the last response of every command sent by c, sorted by
+// command. It returns nothing unless Options.RecordResponses is set.
+func (c *Collector) Responses() []Response {
+	c.responsesMutex.Lock()
+	defer c.responsesMutex.Unlock()
+
+	responses := make([]Response, 0, len(c.responses))
+
+	for _, r := range c.responses {
+		responses = append(responses, r)
+	}
+
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].Command < responses[j].Command
+	})
+
+	return responses
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RoleDetection detects whether FreeSWITCH is the active or the standby node
+// of an active/standby pair, on every scrape. It is active if the response of
+// the api command Command matches ActivePattern, or if the sofia profile
+// SofiaProfile exists, e.g. the one bound to the floating address. On the
+// standby, the collectors named by SuppressOnStandby are not scraped, so that
+// call volume dashboards do not count the pair twice.
+type RoleDetection struct {
+	Command       string `yaml:"command"`
+	ActivePattern string `yaml:"active_pattern"`
+	SofiaProfile  string `yaml:"sofia_profile"`
+
+	SuppressOnStandby []string `yaml:"suppress_on_standby"`
+}
+
+// roles are the values of the role label.
+var roles = []string{"active", "standby"}
+
+var roleDesc = prometheus.NewDesc(Namespace+"_role", "Role of FreeSWITCH in an active/standby pair.", []string{"role"}, nil)
+
+// roleDetector is a parsed RoleDetection.
+type roleDetector struct {
+	command  string
+	pattern  *regexp.Regexp
+	profile  string
+	suppress map[string]bool
+}
+
+// Validate checks that def can detect the role.
+func (def RoleDetection) Validate() error {
+	_, err := newRoleDetector(def)
+	return err
+}
+
+func newRoleDetector(def RoleDetection) (*roleDetector, error) {
+	command := strings.TrimSpace(def.Command)
+
+	if (len(command) == 0) == (len(def.SofiaProfile) == 0) {
+		return nil, errors.New("role: either command or sofia_profile is required")
+	}
+
+	d := &roleDetector{profile: def.SofiaProfile, suppress: make(map[string]bool)}
+
+	if len(command) != 0 {
+		if len(def.ActivePattern) == 0 {
+			return nil, errors.New("role: active_pattern is required with command")
+		}
+
+		pattern, err := regexp.Compile(def.ActivePattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("role: active_pattern: %w", err)
+		}
+
+		d.command = "api " + command
+		d.pattern = pattern
+	} else if len(def.ActivePattern) != 0 {
+		return nil, errors.New("role: active_pattern is only used with command")
+	}
+
+	if _, err := enabledScrapers(def.SuppressOnStandby); err != nil {
+		return nil, fmt.Errorf("role: suppress_on_standby: %w", err)
+	}
+
+	for _, name := range def.SuppressOnStandby {
+		d.suppress[name] = true
+	}
+
+	return d, nil
+}
+
+// detectRole returns whether FreeSWITCH is active, and sends its role to ch.
+func (c *Collector) detectRole(ch chan<- prometheus.Metric) (bool, error) {
+	var active bool
+
+	if len(c.role.command) != 0 {
+		response, err := c.fsCommand(c.role.command)
+
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.HasPrefix(response, []byte("-ERR")) {
+			return false, newErrorReply(c.role.command, response)
+		}
+
+		active = c.role.pattern.Match(response)
+	} else {
+		sofia, err := c.sofiaStatus()
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, entry := range sofia {
+			if entry.Type == "profile" && entry.Name == c.role.profile {
+				active = true
+			}
+		}
+	}
+
+	for _, role := range roles {
+		value := 0.0
+
+		if (role == "active") == active {
+			value = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(roleDesc, prometheus.GaugeValue, value, role)
+	}
+
+	return active, nil
+}
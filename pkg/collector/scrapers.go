@@ -0,0 +1,280 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scraper is a named group of polled metrics, enabled when its name is in
+// Options.Collectors.
+type scraper struct {
+	name    string
+	help    string
+	enabled bool
+	scrape  func(c *Collector, ch chan<- prometheus.Metric) error
+}
+
+var (
+	collectorSuccessDesc   = prometheus.NewDesc(Namespace+"_collector_success", "Was the last scrape of the collector successful", []string{"collector"}, nil)
+	collectorLastErrorDesc = prometheus.NewDesc(Namespace+"_collector_last_error", "Error of the last scrape of the collector, exported while it fails.", []string{"collector", "error"}, nil)
+	collectorStaleDesc     = prometheus.NewDesc(Namespace+"_collector_stale_seconds", "Age of the metrics of the collector, 0 unless those of its last successful scrape are served as it failed.", []string{"collector"}, nil)
+)
+
+// maxErrorLength bounds the length of the error label of
+// freeswitch_collector_last_error, in characters.
+const maxErrorLength = 200
+
+// errorLabel returns the error label of err: its message on a single line,
+// truncated to maxErrorLength characters.
+func errorLabel(err error) string {
+	message := strings.Join(strings.Fields(strings.ToValidUTF8(err.Error(), "?")), " ")
+
+	if runes := []rune(message); len(runes) > maxErrorLength {
+		message = string(runes[:maxErrorLength-3]) + "..."
+	}
+
+	return message
+}
+
+// scrapers are run in this order on every scrape.
+var scrapers = []scraper{
+	{"core", "uptime, time sync, pause and shutdown state, loaded modules", true, (*Collector).scapeMetrics},
+	{"status", "sessions and sessions per second from status", true, (*Collector).scrapeStatus},
+	{"calls", "active calls, in total and by sofia profile", true, withSofiaStatus((*Collector).scrapeCalls)},
+	{"sofia", "profile counts, gateway registration state and expiry", true, withSofiaStatus((*Collector).scrapeSofia)},
+	{"tls", "sofia TLS certificates expiry", true, withSofiaStatus((*Collector).scrapeTLSCertificates)},
+	{"codecs", "loaded codecs", true, (*Collector).scrapeCodecs},
+	{"callcenter", "mod_callcenter agents and queue members", false, (*Collector).scrapeCallcenter},
+	{"channels", "channel variable metrics of the live channels", true, (*Collector).scrapeChannels},
+	{"commands", "metrics of the commands declared in the configuration file", true, (*Collector).scrapeCommands},
+}
+
+// CollectorInfo describes a collector, the name of a group of metrics that
+// can be enabled with Options.Collectors.
+type CollectorInfo struct {
+	Name    string
+	Help    string
+	Enabled bool // by default
+}
+
+// Collectors returns the available collectors, in scrape order.
+func Collectors() []CollectorInfo {
+	var infos []CollectorInfo
+
+	for _, s := range scrapers {
+		infos = append(infos, CollectorInfo{s.name, s.help, s.enabled})
+	}
+
+	return infos
+}
+
+// defaultScrapers returns the names of the scrapers enabled by default.
+func defaultScrapers() []string {
+	var names []string
+
+	for _, s := range scrapers {
+		if s.enabled {
+			names = append(names, s.name)
+		}
+	}
+
+	return names
+}
+
+// enabledScrapers returns the scrapers named names, in scrape order.
+func enabledScrapers(names []string) ([]scraper, error) {
+	wanted := make(map[string]bool)
+
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var enabled []scraper
+
+	for _, s := range scrapers {
+		if wanted[s.name] {
+			enabled = append(enabled, s)
+			delete(wanted, s.name)
+		}
+	}
+
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown collector %q", name)
+	}
+
+	return enabled, nil
+}
+
+// cachedScrape holds the metrics of the last successful run of a scraper.
+type cachedScrape struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// runScraper runs s, or sends the metrics of its previous run if they are
+// more recent than its cache TTL. If s fails, the metrics of its last
+// successful run are sent instead if they are more recent than the max
+// staleness, and their age is returned.
+func (c *Collector) runScraper(s scraper, ch chan<- prometheus.Metric) (time.Duration, error) {
+	ttl := c.cacheTTLs[s.name]
+
+	if ttl <= 0 && c.maxStaleness <= 0 {
+		return 0, s.scrape(c, ch)
+	}
+
+	c.cacheMutex.Lock()
+	cached, ok := c.cache[s.name]
+	c.cacheMutex.Unlock()
+
+	if ok && time.Since(cached.time) < ttl {
+		for _, metric := range cached.metrics {
+			ch <- metric
+		}
+
+		return 0, nil
+	}
+
+	scrape := func(ch chan<- prometheus.Metric) error {
+		return s.scrape(c, ch)
+	}
+
+	// metrics are forwarded as they are scraped, and kept for the next runs
+	if c.maxStaleness <= 0 {
+		metrics, err := record(ch, scrape)
+
+		if err != nil {
+			return 0, err
+		}
+
+		c.cacheMutex.Lock()
+		c.cache[s.name] = cachedScrape{metrics: metrics, time: time.Now()}
+		c.cacheMutex.Unlock()
+
+		return 0, nil
+	}
+
+	// a failed run may have sent some metrics, so they are only forwarded
+	// once it completes
+	metrics, err := gather(scrape)
+
+	var age time.Duration
+
+	if err == nil {
+		c.cacheMutex.Lock()
+		c.cache[s.name] = cachedScrape{metrics: metrics, time: time.Now()}
+		c.cacheMutex.Unlock()
+	} else if ok && time.Since(cached.time) < c.maxStaleness {
+		metrics = cached.metrics
+		age = time.Since(cached.time)
+	}
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+
+	return age, err
+}
+
+// serveStale sends the metrics of the last successful run of the scrapers,
+// and their age, if they are more recent than the max staleness, when
+// FreeSWITCH cannot be scraped.
+func (c *Collector) serveStale(ch chan<- prometheus.Metric, scrapers []scraper) {
+	for _, s := range scrapers {
+		c.cacheMutex.Lock()
+		cached, ok := c.cache[s.name]
+		c.cacheMutex.Unlock()
+
+		age := time.Since(cached.time)
+
+		if !ok || age >= c.maxStaleness {
+			continue
+		}
+
+		for _, metric := range cached.metrics {
+			ch <- metric
+		}
+
+		ch <- prometheus.MustNewConstMetric(collectorStaleDesc, prometheus.GaugeValue, age.Seconds(), s.name)
+	}
+}
+
+// withSofiaStatus adapts scrapers of "sofia status" entries, so that the
+// command is only sent once per scrape.
+func withSofiaStatus(scrape func(c *Collector, ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) error) func(c *Collector, ch chan<- prometheus.Metric) error {
+	return func(c *Collector, ch chan<- prometheus.Metric) error {
+		sofia, err := c.sofiaStatus()
+
+		if err != nil {
+			return err
+		}
+
+		return scrape(c, ch, sofia)
+	}
+}
+
+// sofiaStatus returns the "sofia status" entries of the current scrape,
+// fetching them on the first call.
+func (c *Collector) sofiaStatus() ([]sofiaStatusEntry, error) {
+	c.sofiaMutex.Lock()
+	defer c.sofiaMutex.Unlock()
+
+	if !c.sofiaFetched {
+		sofia, err := c.fetchSofiaStatus()
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.sofia = sofia
+		c.sofiaFetched = true
+	}
+
+	return c.sofia, nil
+}
+
+// gather returns the metrics sent by f, along with the error of f.
+func gather(f func(chan<- prometheus.Metric) error) ([]prometheus.Metric, error) {
+	sink := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+
+	go func() {
+		var metrics []prometheus.Metric
+
+		for metric := range sink {
+			metrics = append(metrics, metric)
+		}
+
+		done <- metrics
+	}()
+
+	err := f(sink)
+	close(sink)
+
+	return <-done, err
+}
+
+// record forwards the metrics sent by f to ch, and returns them along with
+// the error of f.
+func record(ch chan<- prometheus.Metric, f func(chan<- prometheus.Metric) error) ([]prometheus.Metric, error) {
+	tee := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+
+	go func() {
+		var metrics []prometheus.Metric
+
+		for metric := range tee {
+			metrics = append(metrics, metric)
+			ch <- metric
+		}
+
+		done <- metrics
+	}()
+
+	err := f(tee)
+	close(tee)
+
+	return <-done, err
+}
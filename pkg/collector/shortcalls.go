@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shortCallMetric counts the answered channels whose billed duration is under
+// a threshold, a spike of short calls being a sign of fraud or audio issues.
+type shortCallMetric struct {
+	threshold float64
+	calls     *prometheus.CounterVec
+}
+
+func newShortCallMetric(threshold time.Duration) *shortCallMetric {
+	return &shortCallMetric{
+		threshold: threshold.Seconds(),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "short_calls_total",
+			Help:      "Number of answered channels hung up with a billed duration under the short call threshold, by gateway.",
+		}, []string{"gateway"}),
+	}
+}
+
+// handleHangup counts a CHANNEL_HANGUP_COMPLETE event. Every leg of a call is
+// counted, legs that did not go through a gateway have an empty gateway.
+func (m *shortCallMetric) handleHangup(event Event) {
+	if answered := event["variable_answer_epoch"]; answered == "" || answered == "0" {
+		return
+	}
+
+	billsec, err := strconv.ParseFloat(event["variable_billsec"], 64)
+
+	if err != nil || billsec >= m.threshold {
+		return
+	}
+
+	m.calls.WithLabelValues(event["variable_sip_gateway_name"]).Inc()
+}
@@ -0,0 +1,387 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sofiaStatusEntry is a line of the "sofia status" table, or an element of
+// "sofia xmlstatus" (<profile>, <alias> or <gateway>).
+type sofiaStatusEntry struct {
+	Name  string `xml:"name"`
+	Type  string `xml:"type"`
+	Data  string `xml:"data"`
+	State string `xml:"state"`
+}
+
+var (
+	// gatewayStates are the registration states of sofia gateways.
+	gatewayStates = []string{
+		"UNREGED",
+		"TRYING",
+		"REGISTER",
+		"REGED",
+		"UNREGISTER",
+		"FAILED",
+		"FAIL_WAIT",
+		"EXPIRED",
+		"NOREG",
+		"DOWN",
+		"TIMEOUT",
+	}
+)
+
+// fetchSofiaStatus returns the profiles, aliases and gateways listed by
+// "sofia xmlstatus", or by "sofia status" on the releases without it. It
+// returns no entries if mod_sofia is not loaded.
+func (c *Collector) fetchSofiaStatus() ([]sofiaStatusEntry, error) {
+	if !c.useText("sofia status") {
+		response, err := c.fsCommand("api sofia xmlstatus")
+
+		if err != nil {
+			return nil, err
+		}
+
+		// errors are left to sofia status, mod_sofia may not be loaded
+		if !bytes.HasPrefix(response, []byte("-ERR")) {
+			var status struct {
+				Entries []sofiaStatusEntry `xml:",any"`
+			}
+
+			err := decodeXML(response, &status)
+
+			if err == nil {
+				return filterSofiaStatus(status.Entries), nil
+			}
+
+			c.fallBackToText("sofia status", err)
+		}
+	}
+
+	response, err := c.fsCommand("api sofia status")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(response, []byte("-ERR")) {
+		return nil, nil
+	}
+
+	var entries []sofiaStatusEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+
+		if len(fields) != 4 {
+			continue
+		}
+
+		entries = append(entries, sofiaStatusEntry{
+			Name:  strings.TrimSpace(fields[0]),
+			Type:  strings.TrimSpace(fields[1]),
+			Data:  strings.TrimSpace(fields[2]),
+			State: strings.TrimSpace(fields[3]),
+		})
+	}
+
+	return filterSofiaStatus(entries), nil
+}
+
+// filterSofiaStatus returns the profiles, aliases and gateways of entries.
+func filterSofiaStatus(entries []sofiaStatusEntry) []sofiaStatusEntry {
+	var filtered []sofiaStatusEntry
+
+	for _, entry := range entries {
+		entry.Name = strings.TrimSpace(entry.Name)
+		entry.Type = strings.TrimSpace(entry.Type)
+		entry.Data = strings.TrimSpace(entry.Data)
+		entry.State = strings.TrimSpace(entry.State)
+
+		switch entry.Type {
+		case "profile", "alias", "gateway":
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
+
+// scrapeSofia exports the number of profiles, aliases and gateways, and the
+// registration state and expiry of each gateway.
+func (c *Collector) scrapeSofia(ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) error {
+	scrapeSofiaCounts(ch, sofia)
+
+	if err := c.scrapeGateways(ch, sofia); err != nil {
+		return err
+	}
+
+	return c.scrapeGatewayExpiry(ch, sofia)
+}
+
+// scrapeSofiaCounts exports the number of entries of each type, the counts of
+// the footer of "sofia status" along with the gateways, so that a profile
+// that fails to start, e.g. after reloadxml, shows as one less.
+func scrapeSofiaCounts(ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) {
+	counts := map[string]float64{"profile": 0, "alias": 0, "gateway": 0}
+
+	for _, entry := range sofia {
+		counts[entry.Type]++
+	}
+
+	for _, count := range []struct{ name, entryType, help string }{
+		{"profiles", "profile", "Number of sofia profiles, as listed by sofia status"},
+		{"aliases", "alias", "Number of sofia profile aliases, as listed by sofia status"},
+		{"gateways", "gateway", "Number of sofia gateways, as listed by sofia status"},
+	} {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(Namespace+"_sofia_"+count.name, count.help, nil, nil),
+			prometheus.GaugeValue,
+			counts[count.entryType],
+		)
+	}
+}
+
+// scrapeGateways exports the registration state of each gateway.
+func (c *Collector) scrapeGateways(ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) error {
+	desc := prometheus.NewDesc(Namespace+"_gateway_state", "Registration state of the gateway", []string{"gateway", "state"}, nil)
+
+	for _, entry := range sofia {
+		if entry.Type != "gateway" {
+			continue
+		}
+
+		gateway := gatewayName(entry.Name)
+
+		state := strings.Fields(entry.State)
+
+		for _, s := range gatewayStates {
+			value := 0.0
+
+			if len(state) > 0 && state[0] == s {
+				value = 1
+			}
+
+			metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, gateway, s)
+
+			if err != nil {
+				return err
+			}
+
+			ch <- metric
+		}
+	}
+
+	return nil
+}
+
+// fetchGatewayStatus returns the fields listed by "sofia status gateway", e.g.
+// EXPIRES or STATE. It returns nil if the gateway does not exist.
+func (c *Collector) fetchGatewayStatus(gateway string) (map[string]string, error) {
+	return c.fetchSofiaFields("gateway " + gateway)
+}
+
+// fetchProfileStatus returns the fields listed by "sofia status profile", e.g.
+// TLS-BIND-URL. It returns nil if the profile does not exist.
+func (c *Collector) fetchProfileStatus(profile string) (map[string]string, error) {
+	return c.fetchSofiaFields("profile " + profile)
+}
+
+// fetchSofiaFields returns the fields of "sofia xmlstatus <object>", keyed by
+// their upper case name. The tab separated fields of "sofia status <object>"
+// are read instead if the former is not supported, or fails (e.g. for an
+// object that does not exist, which is not answered in XML).
+func (c *Collector) fetchSofiaFields(object string) (map[string]string, error) {
+	if !c.useText("sofia status") {
+		response, err := c.fsCommand("api sofia xmlstatus " + object)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var node xmlNode
+
+		if decodeXML(response, &node) == nil {
+			if fields := xmlFields(node); len(fields) != 0 {
+				return fields, nil
+			}
+		}
+	}
+
+	response, err := c.fsCommand("api sofia status " + object)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "\t")
+
+		if !found {
+			continue
+		}
+
+		fields[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return fields, nil
+}
+
+// handleGatewayState records when gateways registered, from sofia::gateway_state
+// events. The registration is kept while it is being refreshed.
+func (c *Collector) handleGatewayState(event Event) {
+	c.gatewayMutex.Lock()
+	defer c.gatewayMutex.Unlock()
+
+	gateway := event["Gateway"]
+
+	switch event["State"] {
+	case "REGED":
+		registered := time.Now()
+
+		if usec, err := strconv.ParseInt(event["Event-Date-Timestamp"], 10, 64); err == nil {
+			registered = time.UnixMicro(usec)
+		}
+
+		c.gatewayRegistrations[gateway] = registered
+	case "REGISTER", "TRYING":
+	default:
+		delete(c.gatewayRegistrations, gateway)
+	}
+}
+
+// clearGatewayRegistrations forgets the registration times, as state changes
+// may be missed while the event connection is down.
+func (c *Collector) clearGatewayRegistrations() {
+	c.gatewayMutex.Lock()
+	defer c.gatewayMutex.Unlock()
+
+	c.gatewayRegistrations = make(map[string]time.Time)
+}
+
+// scrapeGatewayExpiry exports the seconds until the registration of each
+// registered gateway expires, from the expires and freq fields of sofia status
+// gateway. These are periods, so the registration time is taken from the
+// first scrape listing the gateway as registered, or from the last
+// sofia::gateway_state event with events, which is exact.
+func (c *Collector) scrapeGatewayExpiry(ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) error {
+	desc := prometheus.NewDesc(Namespace+"_gateway_registration_expiry_seconds", "Seconds until the registration of the gateway expires", []string{"gateway"}, nil)
+	now := time.Now()
+	registrations := make(map[string]time.Time)
+
+	c.gatewayMutex.Lock()
+
+	for _, entry := range sofia {
+		if entry.Type != "gateway" || !strings.HasPrefix(entry.State, "REGED") {
+			continue
+		}
+
+		gateway := gatewayName(entry.Name)
+		registered, ok := c.gatewayRegistrations[gateway]
+
+		if !ok {
+			registered = now
+		}
+
+		registrations[gateway] = registered
+	}
+
+	// forget the gateways no longer registered
+	c.gatewayRegistrations = registrations
+	c.gatewayMutex.Unlock()
+
+	for _, entry := range sofia {
+		gateway := gatewayName(entry.Name)
+		registered, ok := registrations[gateway]
+
+		if entry.Type != "gateway" || !ok {
+			continue
+		}
+
+		status, err := c.fetchGatewayStatus(gateway)
+
+		if err != nil {
+			return err
+		}
+
+		if status == nil {
+			continue
+		}
+
+		// expires is updated with the value granted by the registrar
+		expires, err := strconv.ParseFloat(status["EXPIRES"], 64)
+
+		if err != nil {
+			return fmt.Errorf("cannot read gateway expires: %w", err)
+		}
+
+		freq, _ := strconv.ParseFloat(status["FREQ"], 64)
+
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, gatewayExpiry(registered, now, expires, freq), gateway)
+
+		if err != nil {
+			return err
+		}
+
+		ch <- metric
+	}
+
+	return nil
+}
+
+// gatewayExpiry returns the seconds until a registration of expires seconds
+// made at registered expires. FreeSWITCH refreshes registrations every freq
+// seconds (or expires if freq is not set), so the registration is assumed to
+// have been refreshed at the last multiple of it since registered.
+func gatewayExpiry(registered, now time.Time, expires, freq float64) float64 {
+	if freq <= 0 || freq > expires {
+		freq = expires
+	}
+
+	elapsed := now.Sub(registered).Seconds()
+
+	if freq > 0 && elapsed > freq {
+		elapsed = math.Mod(elapsed, freq)
+	}
+
+	return expires - elapsed
+}
+
+// gatewayName returns the name of a gateway listed by "sofia status" as
+// profile::gateway.
+func gatewayName(name string) string {
+	if i := strings.Index(name, "::"); i >= 0 {
+		return name[i+2:]
+	}
+
+	return name
+}
+
+// channelProfile returns the sofia profile of a channel name such as
+// "sofia/internal/1000@example.com", or an empty string for other endpoints.
+func channelProfile(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+
+	if len(parts) < 3 || parts[0] != "sofia" {
+		return ""
+	}
+
+	return parts[1]
+}
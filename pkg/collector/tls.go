@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// tlsCertificate and wssCertificate are the certificates sofia loads from
+	// tls-cert-dir for SIP over TLS and secure websockets.
+	tlsCertificate = "agent.pem"
+	wssCertificate = "wss.pem"
+)
+
+// scrapeTLSCertificates exports the expiry of the certificates of the sofia
+// profiles with TLS or WSS enabled. Certificates are read from the certs dir,
+// unless configured for the profile. Unreadable certificates are skipped, as
+// they may not be on the exporter host.
+func (c *Collector) scrapeTLSCertificates(ch chan<- prometheus.Metric, sofia []sofiaStatusEntry) error {
+	if len(c.certsDir) == 0 && len(c.tlsCertificates) == 0 {
+		return nil
+	}
+
+	desc := prometheus.NewDesc(Namespace+"_profile_tls_cert_expiry_timestamp_seconds", "Expiry date of the TLS certificate of the sofia profile", []string{"profile", "file"}, nil)
+
+	for _, entry := range sofia {
+		if entry.Type != "profile" {
+			continue
+		}
+
+		var files []string
+
+		if file, ok := c.tlsCertificates[entry.Name]; ok {
+			files = append(files, file)
+		} else if len(c.certsDir) != 0 {
+			status, err := c.fetchProfileStatus(entry.Name)
+
+			if err != nil {
+				return err
+			}
+
+			if enabled(status["TLS-BIND-URL"]) {
+				files = append(files, filepath.Join(c.certsDir, tlsCertificate))
+			}
+
+			if enabled(status["WSS-BIND-URL"]) {
+				files = append(files, filepath.Join(c.certsDir, wssCertificate))
+			}
+		}
+
+		for _, file := range files {
+			expiry, err := certificateExpiry(file)
+
+			if err != nil {
+				level.Warn(c.logger).Log("msg", "Cannot read certificate", "profile", entry.Name, "file", file, "err", err)
+				continue
+			}
+
+			metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, float64(expiry.Unix()), entry.Name, file)
+
+			if err != nil {
+				return err
+			}
+
+			ch <- metric
+		}
+	}
+
+	return nil
+}
+
+// certificateExpiry returns the expiry date of the first certificate of the
+// PEM file (sofia certificates usually also hold the private key).
+func certificateExpiry(file string) (t time.Time, err error) {
+	content, err := os.ReadFile(file)
+
+	if err != nil {
+		return t, err
+	}
+
+	for {
+		var block *pem.Block
+
+		block, content = pem.Decode(content)
+
+		if block == nil {
+			return t, errors.New("no certificate found")
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+
+		if err != nil {
+			return t, fmt.Errorf("cannot parse certificate: %w", err)
+		}
+
+		return cert.NotAfter, nil
+	}
+}
+
+// enabled reports whether a sofia status field is set.
+func enabled(value string) bool {
+	return len(value) != 0 && value != "N/A"
+}
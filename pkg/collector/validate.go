@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"fmt"
+)
+
+// Validate checks that a Collector can be created with uri and options,
+// without connecting to FreeSWITCH: the URI, the proxy, the collector names
+// and the definitions of the metrics. It returns the first error New would
+// return.
+func Validate(uri string, options Options) error {
+	urls, err := parseURIs(uri)
+
+	if err != nil {
+		return err
+	}
+
+	events := options.Heartbeat || options.Events || len(options.EmergencyPatterns) > 0 ||
+		options.ShortCallThreshold > 0 || options.LowMOSThreshold > 0 || len(options.CallsPeakWindows) > 0 || len(options.ProbeCall) != 0
+
+	for _, def := range options.ChannelVariables {
+		events = events || def.Source != "channels"
+	}
+
+	if scheme := urls[0].Scheme; events && (scheme == "http" || scheme == "https") {
+		return fmt.Errorf("events cannot be received over %s", scheme)
+	}
+
+	if scheme := urls[0].Scheme; len(options.Proxy) != 0 && (scheme == "http" || scheme == "https") {
+		return fmt.Errorf("cannot connect over %s through a proxy, only event socket URIs are supported", scheme)
+	}
+
+	if scheme := urls[0].Scheme; len(options.FallbackPasswords) != 0 && (scheme == "http" || scheme == "https") {
+		return fmt.Errorf("fallback passwords are not supported over %s, only event socket URIs are supported", scheme)
+	}
+
+	if _, err := newNetDialer(options); err != nil {
+		return err
+	}
+
+	names := options.Collectors
+
+	if names == nil {
+		names = defaultScrapers()
+	}
+
+	if _, err := enabledScrapers(names); err != nil {
+		return err
+	}
+
+	for name := range options.CacheTTLs {
+		if _, err := enabledScrapers([]string{name}); err != nil {
+			return fmt.Errorf("cache TTL: %w", err)
+		}
+	}
+
+	for _, def := range options.Commands {
+		if _, err := newCommandMetric(def); err != nil {
+			return err
+		}
+	}
+
+	if len(options.ProbeCall) != 0 {
+		if _, err := newProbeCallOptions(nil, options, nil); err != nil {
+			return err
+		}
+	}
+
+	if options.Role != nil {
+		if err := options.Role.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, def := range options.ChannelVariables {
+		if _, err := newChannelVariableMetric(def); err != nil {
+			return err
+		}
+	}
+
+	if len(options.EmergencyPatterns) > 0 {
+		if _, err := newEmergencyMetric(options.EmergencyPatterns); err != nil {
+			return err
+		}
+	}
+
+	if len(options.CallsPeakWindows) > 0 {
+		if _, err := newCallTracker(options.CallsPeakWindows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
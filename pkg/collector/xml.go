@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// xmlNode is an element of an XML response, kept generic so that elements
+// added or reordered by new FreeSWITCH versions do not break parsing.
+type xmlNode struct {
+	XMLName xml.Name
+	Content string    `xml:",chardata"`
+	Nodes   []xmlNode `xml:",any"`
+}
+
+// decodeXML decodes the XML response of a command into v. The declared
+// charset is ignored: FreeSWITCH declares ISO-8859-1, but sends names as they
+// were configured.
+func decodeXML(response []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(response))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	return decoder.Decode(v)
+}
+
+// xmlFields returns the content of the elements of node without children,
+// keyed by their upper case name, e.g. EXPIRES for <expires>. The first
+// element of a name wins.
+func xmlFields(node xmlNode) map[string]string {
+	fields := make(map[string]string)
+
+	var walk func(node xmlNode)
+
+	walk = func(node xmlNode) {
+		for _, child := range node.Nodes {
+			if len(child.Nodes) != 0 {
+				walk(child)
+				continue
+			}
+
+			key := strings.ToUpper(child.XMLName.Local)
+
+			if _, ok := fields[key]; !ok {
+				fields[key] = strings.TrimSpace(child.Content)
+			}
+		}
+	}
+
+	walk(node)
+
+	return fields
+}
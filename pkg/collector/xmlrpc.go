@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// httpCommand sends the api command to the web API of mod_xml_rpc, e.g.
+// "api show calls as json" to /txtapi/show?calls%20as%20json, authenticated
+// with the user and password of c.
+func (c *Collector) httpCommand(command string, deadline time.Time) (response []byte, sent, received time.Time, err error) {
+	api := strings.TrimPrefix(command, "api ")
+
+	if api == command {
+		return nil, sent, received, fmt.Errorf("cannot send %q over %s, only api commands are supported", command, c.url.Scheme)
+	}
+
+	name, args, _ := strings.Cut(api, " ")
+
+	u := *c.url
+	u.Path = path.Join(c.url.Path, "txtapi", name)
+	u.RawQuery = strings.ReplaceAll(url.QueryEscape(args), "+", "%20")
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return nil, sent, received, err
+	}
+
+	// probes of unlisted targets send no credentials
+	if len(c.User) != 0 || len(c.Password) != 0 {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	sent = time.Now()
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return nil, sent, time.Now(), transientError{err}
+	}
+
+	defer resp.Body.Close()
+
+	response, err = io.ReadAll(resp.Body)
+	received = time.Now()
+
+	if err != nil {
+		return nil, sent, received, fmt.Errorf("cannot read command response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, sent, received, fmt.Errorf("command failed: %s", resp.Status)
+	}
+
+	return response, sent, received, nil
+}
+
+// checkHTTP checks that mod_xml_rpc answers before deadline, as there is no
+// connection to establish.
+func (c *Collector) checkHTTP(deadline time.Time) error {
+	_, _, _, err := c.httpCommand("api version", deadline)
+	return err
+}
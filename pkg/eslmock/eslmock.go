@@ -0,0 +1,419 @@
+// Package eslmock is a mock FreeSWITCH event socket, serving canned responses
+// to api commands, to test the collector (or anything speaking the event
+// socket protocol) without a running FreeSWITCH.
+//
+//	server, err := eslmock.New("ClueCon")
+//	...
+//	defer server.Close()
+//
+//	server.Respond("uptime s", "3600\n")
+//	c, err := collector.New(server.URI(), 5*time.Second, "ClueCon", collector.Options{})
+package eslmock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the default response of the status api command.
+const Status = `UP 0 years, 0 days, 1 hour, 2 minutes, 3 seconds, 0 milliseconds, 0 microseconds
+FreeSWITCH (Version 1.10.9-release 64bit) is ready
+53 session(s) since startup
+3 session(s) - peak 6, last 5min 4
+1 session(s) per Sec out of max 30, peak 5, last 5min 2
+1000 session(s) max
+min idle cpu 0.00/97.50
+Current Stack Size/Max 240K/8192K
+`
+
+// JSONStatus is the default response of the json status api command, with
+// the values of Status.
+const JSONStatus = `{"command":"status","status":"success","response":{"systemStatus":"ready",` +
+	`"sessions":{"count":{"total":53,"active":3,"peak":6,"peak5Min":4,"limit":1000},"rate":{"current":1,"max":30,"peak":5,"peak5Min":2}},` +
+	`"idleCPU":{"used":0,"allowed":97.5}}}`
+
+// defaultResponses are the responses of the commands sent by the default
+// collectors, for a FreeSWITCH without calls, sofia profiles or codecs.
+var defaultResponses = map[string]string{
+	"uptime s":                            "3723\n",
+	"fsctl pause_check inbound":           "false",
+	"fsctl pause_check outbound":          "false",
+	"fsctl shutdown_check":                "false",
+	"show application count as json":      `{"row_count":0}`,
+	"show api count as json":              `{"row_count":0}`,
+	"show calls as json":                  `{"row_count":0}`,
+	"show channels as json":               `{"row_count":0}`,
+	"show codec as json":                  `{"row_count":0}`,
+	"status":                              Status,
+	`json {"command":"status","data":""}`: JSONStatus,
+	"sofia xmlstatus":                     "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<profiles>\n</profiles>\n",
+	"sofia status":                        "                     Name\t   Type\t                                      Data\tState\n=================================================================================================\n=================================================================================================\n0 profiles 0 aliases\n",
+	"version":                             "FreeSWITCH Version 1.10.9-release 64bit\n",
+}
+
+// Server is a mock event socket, listening on the loopback interface. Api
+// commands are answered with the responses set by Respond and RespondFunc, or
+// with the default ones, other commands with -ERR. Bgapi commands are
+// answered likewise, with a BACKGROUND_JOB event. Events are sent to the
+// connections subscribed with "event plain", and once a connection sets a
+// "filter", only the events with the header value of one of its filters.
+type Server struct {
+	listener net.Listener
+	password string
+
+	mutex     sync.Mutex
+	responses map[string]func() string
+	commands  []string
+	conns     map[net.Conn]*conn
+	jobs      int
+
+	wg sync.WaitGroup
+}
+
+// conn is a connection to the server, which can receive events once
+// subscribed.
+type conn struct {
+	net.Conn
+
+	// mutex serializes writes, events being sent from other goroutines
+	mutex      sync.Mutex
+	subscribed bool
+	// header values of the "filter" commands
+	filters [][2]string
+}
+
+// New starts a server accepting auth and userauth with password.
+func New(password string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener:  listener,
+		password:  password,
+		responses: make(map[string]func() string),
+		conns:     make(map[net.Conn]*conn),
+	}
+
+	for command, response := range defaultResponses {
+		s.Respond(command, response)
+	}
+
+	s.RespondFunc("strepoch", func() string {
+		return strconv.FormatInt(time.Now().Unix(), 10)
+	})
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// URI returns the scrape URI of the server, e.g. tcp://127.0.0.1:38021.
+func (s *Server) URI() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+// Respond sets the response of the api command (without "api "), e.g.
+// Respond("uptime s", "3600\n"). A response starting with -ERR is an error.
+func (s *Server) Respond(command, response string) {
+	s.RespondFunc(command, func() string {
+		return response
+	})
+}
+
+// RespondFunc sets the function returning the response of the api command,
+// called every time the command is received.
+func (s *Server) RespondFunc(command string, f func() string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.responses[command] = f
+}
+
+// Commands returns the commands received, in order, except authentication.
+func (s *Server) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// SendEvent sends an event with headers to the connections subscribed with
+// "event plain" whose filters it matches, e.g. {"Event-Name": "HEARTBEAT",
+// "Session-Count": "3"}. It returns the number of connections it was sent to.
+func (s *Server) SendEvent(headers map[string]string) int {
+	return s.sendEvent(headers, "")
+}
+
+// sendEvent sends an event with headers, and body if not empty.
+func (s *Server) sendEvent(headers map[string]string, body string) int {
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var event strings.Builder
+
+	for _, name := range names {
+		fmt.Fprintf(&event, "%s: %s\n", name, url.PathEscape(headers[name]))
+	}
+
+	if len(body) != 0 {
+		fmt.Fprintf(&event, "Content-Length: %d\n", len(body))
+	}
+
+	event.WriteString("\n")
+	event.WriteString(body)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sent := 0
+
+	for _, c := range s.conns {
+		c.mutex.Lock()
+
+		if c.subscribed && c.matches(headers) {
+			fmt.Fprintf(c, "Content-Length: %d\nContent-Type: text/event-plain\n\n%s", event.Len(), event.String())
+			sent++
+		}
+
+		c.mutex.Unlock()
+	}
+
+	return sent
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mutex.Lock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		c := &conn{Conn: nc}
+
+		s.mutex.Lock()
+		s.conns[nc] = c
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			s.handle(c)
+
+			s.mutex.Lock()
+			delete(s.conns, c.Conn)
+			s.mutex.Unlock()
+
+			c.Close()
+		}()
+	}
+}
+
+// handle authenticates c, and answers its commands until it exits or is
+// closed.
+func (s *Server) handle(c *conn) {
+	input := bufio.NewReader(c)
+
+	if !c.write("Content-Type: auth/request\n\n") {
+		return
+	}
+
+	command, _, err := readCommand(input)
+
+	if err != nil {
+		return
+	}
+
+	password := strings.TrimPrefix(command, "auth ")
+
+	// userauth user@domain:password
+	if strings.HasPrefix(command, "userauth ") {
+		_, password, _ = strings.Cut(strings.TrimPrefix(command, "userauth "), ":")
+	} else if password == command {
+		password = ""
+	}
+
+	if password != s.password {
+		c.write("Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+		return
+	}
+
+	if !c.write("Content-Type: command/reply\nReply-Text: +OK accepted\n\n") {
+		return
+	}
+
+	for {
+		command, headers, err := readCommand(input)
+
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.commands = append(s.commands, command)
+		s.mutex.Unlock()
+
+		switch {
+		case command == "exit":
+			c.write("Content-Type: command/reply\nReply-Text: +OK bye\n\n")
+			return
+		case strings.HasPrefix(command, "api "):
+			response := s.response(strings.TrimPrefix(command, "api "))
+			c.write(fmt.Sprintf("Content-Type: api/response\nContent-Length: %d\n\n%s", len(response), response))
+		case strings.HasPrefix(command, "bgapi "):
+			job := s.jobUUID(headers["Job-UUID"])
+			c.write(fmt.Sprintf("Content-Type: command/reply\nReply-Text: +OK Job-UUID: %s\nJob-UUID: %s\n\n", job, job))
+
+			s.wg.Add(1)
+
+			go func(command string) {
+				defer s.wg.Done()
+
+				response := s.response(strings.TrimPrefix(command, "bgapi "))
+				s.sendEvent(map[string]string{"Event-Name": "BACKGROUND_JOB", "Job-UUID": job, "Job-Command": command}, response)
+			}(command)
+		case strings.HasPrefix(command, "filter "):
+			header, value, _ := strings.Cut(strings.TrimPrefix(command, "filter "), " ")
+
+			c.mutex.Lock()
+			c.filters = append(c.filters, [2]string{header, value})
+			c.mutex.Unlock()
+
+			c.write(fmt.Sprintf("Content-Type: command/reply\nReply-Text: +OK filter added. [%s]=[%s]\n\n", header, value))
+		case strings.HasPrefix(command, "event plain "):
+			c.mutex.Lock()
+			c.subscribed = true
+			c.mutex.Unlock()
+
+			c.write("Content-Type: command/reply\nReply-Text: +OK event listener enabled plain\n\n")
+		default:
+			c.write("Content-Type: command/reply\nReply-Text: -ERR command not found\n\n")
+		}
+	}
+}
+
+// response returns the response of the api command.
+func (s *Server) response(command string) string {
+	s.mutex.Lock()
+	f, ok := s.responses[command]
+	s.mutex.Unlock()
+
+	if ok {
+		return f()
+	}
+
+	name, _, _ := strings.Cut(command, " ")
+
+	return fmt.Sprintf("-ERR %s Command not found!\n", name)
+}
+
+// jobUUID returns the Job-UUID of a bgapi command, job if set by the client.
+func (s *Server) jobUUID(job string) string {
+	if len(job) != 0 {
+		return job
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs++
+
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.jobs)
+}
+
+// matches returns whether an event with headers passes the filters of c, or
+// true if it has none. The mutex of c must be held.
+func (c *conn) matches(headers map[string]string) bool {
+	if len(c.filters) == 0 {
+		return true
+	}
+
+	for _, filter := range c.filters {
+		if headers[filter[0]] == filter[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write writes message to c, returning whether it succeeded.
+func (c *conn) write(message string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, err := io.WriteString(c, message)
+
+	return err == nil
+}
+
+// readCommand reads a command, terminated by an empty line, and returns its
+// first line and the headers of the other lines, such as the Job-UUID of
+// bgapi or the headers of sendmsg.
+func readCommand(input *bufio.Reader) (string, map[string]string, error) {
+	var command string
+
+	headers := make(map[string]string)
+
+	for {
+		line, err := input.ReadString('\n')
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if len(line) == 0 {
+			if len(command) == 0 {
+				continue
+			}
+
+			return command, headers, nil
+		}
+
+		if len(command) == 0 {
+			command = line
+		} else if name, value, ok := strings.Cut(line, ":"); ok {
+			headers[name] = strings.TrimSpace(value)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package eslmock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// client is a minimal event socket client.
+type client struct {
+	conn   net.Conn
+	reader *textproto.Reader
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(s.URI(), "tcp://"), time.Second)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &client{conn: conn, reader: textproto.NewReader(bufio.NewReader(conn))}
+
+	if header, _ := c.read(t); header.Get("Content-Type") != "auth/request" {
+		t.Fatalf("got %q, want auth/request", header.Get("Content-Type"))
+	}
+
+	return c
+}
+
+// send sends command, with the headers of the next lines.
+func (c *client) send(t *testing.T, command string, headers ...string) {
+	t.Helper()
+
+	lines := append([]string{command}, headers...)
+
+	if _, err := fmt.Fprintf(c.conn, "%s\n\n", strings.Join(lines, "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read reads a message, and returns its headers and body.
+func (c *client) read(t *testing.T) (textproto.MIMEHeader, string) {
+	t.Helper()
+
+	header, err := c.reader.ReadMIMEHeader()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+
+	if _, err := io.ReadFull(c.reader.R, body); err != nil {
+		t.Fatal(err)
+	}
+
+	return header, string(body)
+}
+
+func newServer(t *testing.T) *Server {
+	t.Helper()
+
+	s, err := New("ClueCon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestAuth(t *testing.T) {
+	s := newServer(t)
+
+	for _, test := range []struct {
+		command string
+		reply   string
+	}{
+		{"auth ClueCon", "+OK accepted"},
+		{"userauth exporter@example.com:ClueCon", "+OK accepted"},
+		{"auth secret", "-ERR invalid"},
+		{"userauth exporter@example.com:secret", "-ERR invalid"},
+	} {
+		c := dial(t, s)
+		c.send(t, test.command)
+
+		if header, _ := c.read(t); header.Get("Reply-Text") != test.reply {
+			t.Errorf("%s: got %q, want %q", test.command, header.Get("Reply-Text"), test.reply)
+		}
+	}
+
+	if commands := s.Commands(); len(commands) != 0 {
+		t.Errorf("got commands %q, want none", commands)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := newServer(t)
+	s.Respond("uptime s", "60\n")
+
+	c := dial(t, s)
+	c.send(t, "auth ClueCon")
+	c.read(t)
+
+	for _, test := range []struct {
+		command  string
+		response string
+	}{
+		{"api uptime s", "60\n"},
+		{"api version", "FreeSWITCH Version 1.10.9-release 64bit\n"},
+		{"api callcenter_config agent list", "-ERR callcenter_config Command not found!\n"},
+	} {
+		c.send(t, test.command)
+		header, body := c.read(t)
+
+		if header.Get("Content-Type") != "api/response" || body != test.response {
+			t.Errorf("%s: got %q (%s), want %q", test.command, body, header.Get("Content-Type"), test.response)
+		}
+	}
+
+	c.send(t, "exit")
+
+	if header, _ := c.read(t); header.Get("Reply-Text") != "+OK bye" {
+		t.Errorf("exit: got %q, want +OK bye", header.Get("Reply-Text"))
+	}
+
+	want := []string{"api uptime s", "api version", "api callcenter_config agent list", "exit"}
+
+	if commands := s.Commands(); strings.Join(commands, ",") != strings.Join(want, ",") {
+		t.Errorf("got commands %q, want %q", commands, want)
+	}
+}
+
+func TestBgapi(t *testing.T) {
+	s := newServer(t)
+	s.Respond("originate loopback/9196 &hangup()", "+OK 5f2b8c9e\n")
+
+	c := dial(t, s)
+	c.send(t, "auth ClueCon")
+	c.read(t)
+	c.send(t, "event plain BACKGROUND_JOB")
+	c.read(t)
+
+	for _, job := range []string{"7f4de4bc-17d7-4c3e-a1a8-3bb870f4a3c5", ""} {
+		var headers []string
+
+		if len(job) != 0 {
+			headers = append(headers, "Job-UUID: "+job)
+		}
+
+		c.send(t, "bgapi originate loopback/9196 &hangup()", headers...)
+		header, _ := c.read(t)
+
+		reply := header.Get("Reply-Text")
+
+		if !strings.HasPrefix(reply, "+OK Job-UUID: ") {
+			t.Fatalf("got %q, want +OK Job-UUID", reply)
+		}
+
+		if len(job) == 0 {
+			job = strings.TrimPrefix(reply, "+OK Job-UUID: ")
+		} else if header.Get("Job-UUID") != job {
+			t.Errorf("got Job-UUID %q, want %q", header.Get("Job-UUID"), job)
+		}
+
+		header, event := c.read(t)
+
+		if header.Get("Content-Type") != "text/event-plain" {
+			t.Fatalf("got %q, want text/event-plain", header.Get("Content-Type"))
+		}
+
+		for _, want := range []string{"Event-Name: BACKGROUND_JOB\n", "Job-UUID: " + job + "\n", "\n\n+OK 5f2b8c9e\n"} {
+			if !strings.Contains(event, want) {
+				t.Errorf("event %q does not contain %q", event, want)
+			}
+		}
+	}
+}
+
+func TestSendEvent(t *testing.T) {
+	s := newServer(t)
+
+	c := dial(t, s)
+	c.send(t, "auth ClueCon")
+	c.read(t)
+
+	if n := s.SendEvent(map[string]string{"Event-Name": "HEARTBEAT"}); n != 0 {
+		t.Errorf("sent to %d connections before subscribing, want 0", n)
+	}
+
+	c.send(t, "event plain HEARTBEAT")
+	c.read(t)
+
+	if n := s.SendEvent(map[string]string{"Event-Name": "HEARTBEAT", "Up-Time": "0 years, 1 hour"}); n != 1 {
+		t.Errorf("sent to %d connections, want 1", n)
+	}
+
+	_, event := c.read(t)
+
+	if want := "Event-Name: HEARTBEAT\nUp-Time: 0%20years%2C%201%20hour\n\n"; event != want {
+		t.Errorf("got event %q, want %q", event, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s := newServer(t)
+
+	c := dial(t, s)
+	c.send(t, "auth ClueCon")
+	c.read(t)
+	c.send(t, "filter Application att_xfer")
+
+	if header, _ := c.read(t); header.Get("Reply-Text") != "+OK filter added. [Application]=[att_xfer]" {
+		t.Errorf("got %q, want +OK filter added", header.Get("Reply-Text"))
+	}
+
+	c.send(t, "event plain CHANNEL_EXECUTE")
+	c.read(t)
+
+	if n := s.SendEvent(map[string]string{"Event-Name": "CHANNEL_EXECUTE", "Application": "answer"}); n != 0 {
+		t.Errorf("filtered out event sent to %d connections, want 0", n)
+	}
+
+	if n := s.SendEvent(map[string]string{"Event-Name": "CHANNEL_EXECUTE", "Application": "att_xfer"}); n != 1 {
+		t.Errorf("sent to %d connections, want 1", n)
+	}
+
+	if _, event := c.read(t); !strings.Contains(event, "Application: att_xfer\n") {
+		t.Errorf("got event %q, want att_xfer", event)
+	}
+}
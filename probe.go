@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/config"
+)
+
+// AuthModule is an entry of the auth_modules section of the configuration
+// file, the credentials of the targets probed with
+// /probe?target=<uri>&auth_module=<name>. Its passwords are read from
+// PasswordFile if set, the next ones being fallback passwords, and the
+// default password is used if it has none. TLSConfig applies to https
+// targets (mod_xml_rpc).
+type AuthModule struct {
+	User         string           `yaml:"user"`
+	Password     stringList       `yaml:"password"`
+	PasswordFile stringList       `yaml:"password_file"`
+	TLSConfig    config.TLSConfig `yaml:"tls_config"`
+}
+
+func (m AuthModule) validate(name string) error {
+	if len(m.Password) != 0 && len(m.PasswordFile) != 0 {
+		return fmt.Errorf("auth module %q: password and password_file are mutually exclusive", name)
+	}
+
+	if _, err := config.NewTLSConfig(&m.TLSConfig); err != nil {
+		return fmt.Errorf("auth module %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// probeKey identifies the Collector of a target probed with an auth module,
+// if not empty.
+type probeKey struct {
+	uri    string
+	module string
+}
+
+// errUnlistedTarget rejects the probes of the targets that are not listed in
+// the configuration file, so that the exporter cannot be made to connect to
+// arbitrary hosts, let alone to send them credentials.
+var errUnlistedTarget = errors.New("target is not listed in the configuration file")
+
+// ProbeHandler serves /probe?target=<uri>, scraping the FreeSWITCH instance
+// at uri. Configured targets have their own credentials, or the default
+// ones, and keep their Collector between probes. They can be probed with the
+// credentials of an auth module instead. Other targets are rejected, unless
+// unlisted is set: they are then probed without credentials.
+type ProbeHandler struct {
+	timeout  time.Duration
+	offset   time.Duration
+	password string
+	options  collector.Options
+	targets  map[string]Target
+	modules  map[string]AuthModule
+	unlisted bool
+
+	// labels and namespace of the metrics, see --metrics.const-label and
+	// --metrics.namespace
+	labels    prometheus.Labels
+	namespace string
+
+	// renames of the metric_renames section, and filter dropping metrics by
+	// name if not nil, see --metrics.include and --metrics.exclude
+	renames []MetricRename
+	filter  *metricFilter
+
+	mutex      sync.Mutex
+	collectors map[probeKey]*collector.Collector
+}
+
+// NewProbeHandler returns a new ProbeHandler. Probes end after timeout, or
+// before the Prometheus scrape timeout minus offset. Only the polled metrics
+// of options are used, event-derived metrics need a long-lived collector.
+// The metrics of probes are labeled with labels, renamed to namespace and as
+// listed in renames, and dropped by filter. The targets that are not listed
+// are only probed if unlisted is true.
+func NewProbeHandler(timeout, offset time.Duration, password string, options collector.Options, targets []Target, modules map[string]AuthModule, unlisted bool, labels prometheus.Labels, namespace string, renames []MetricRename, filter *metricFilter) *ProbeHandler {
+	h := ProbeHandler{
+		timeout:  timeout,
+		offset:   offset,
+		password: password,
+		unlisted: unlisted,
+		options: collector.Options{
+			User:       options.User,
+			Codecs:     options.Codecs,
+			Collectors: options.Collectors,
+			Commands:   options.Commands,
+			CacheTTLs:  options.CacheTTLs,
+			Role:       options.Role,
+			Logger:     options.Logger,
+
+			TracerProvider:    options.TracerProvider,
+			FallbackPasswords: options.FallbackPasswords,
+			MaxConnections:    options.MaxConnections,
+			PipelineDepth:     options.PipelineDepth,
+			CommandTimeout:    options.CommandTimeout,
+			Proxy:             options.Proxy,
+			SSHKeyFile:        options.SSHKeyFile,
+			SSHKnownHostsFile: options.SSHKnownHostsFile,
+			Retries:           options.Retries,
+			MaxStaleness:      options.MaxStaleness,
+			RetryBackoff:      options.RetryBackoff,
+			TimeSyncTolerance: options.TimeSyncTolerance,
+		},
+		targets:    make(map[string]Target),
+		modules:    modules,
+		labels:     labels,
+		namespace:  namespace,
+		renames:    renames,
+		filter:     filter,
+		collectors: make(map[probeKey]*collector.Collector),
+	}
+
+	for _, target := range targets {
+		h.targets[target.URI] = target
+	}
+
+	return &h
+}
+
+// collector returns the Collector of the target, authenticating with the
+// auth module if not empty. Collectors of targets that are not configured are
+// not kept, and must be closed after use. Credentials are only sent to the
+// configured targets: the others are rejected with errUnlistedTarget, unless
+// h.unlisted is set and no auth module is requested.
+func (h *ProbeHandler) collector(uri, module string) (c *collector.Collector, keep bool, err error) {
+	auth, ok := h.modules[module]
+
+	if len(module) != 0 && !ok {
+		return nil, false, fmt.Errorf("unknown auth module %q", module)
+	}
+
+	target, configured := h.targets[uri]
+	key := probeKey{uri: uri, module: module}
+
+	if !configured && (!h.unlisted || len(module) != 0) {
+		return nil, false, errUnlistedTarget
+	}
+
+	if configured {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if c, ok := h.collectors[key]; ok {
+			return c, true, nil
+		}
+	}
+
+	// configured targets without credentials use the default ones
+	password := h.password
+	options := h.options
+
+	if !configured {
+		password = ""
+		options.User = ""
+		options.FallbackPasswords = nil
+	} else if len(target.Password) != 0 {
+		password = target.Password[0]
+		options.FallbackPasswords = target.Password[1:]
+		options.User = target.User
+	} else if len(target.User) != 0 {
+		options.User = target.User
+	}
+
+	if len(target.Proxy) != 0 {
+		options.Proxy = target.Proxy
+	}
+
+	if len(module) != 0 {
+		if len(auth.Password) != 0 {
+			password = auth.Password[0]
+			options.FallbackPasswords = auth.Password[1:]
+			options.User = auth.User
+		} else if len(auth.User) != 0 {
+			options.User = auth.User
+		}
+
+		if options.TLSConfig, err = config.NewTLSConfig(&auth.TLSConfig); err != nil {
+			return nil, false, fmt.Errorf("auth module %q: %w", module, err)
+		}
+	}
+
+	c, err = collector.New(uri, h.timeout, password, options)
+
+	if err != nil || !configured {
+		return c, false, err
+	}
+
+	h.collectors[key] = c
+
+	return c, true, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+
+	if len(target) == 0 {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	c, keep, err := h.collector(target, r.URL.Query().Get("auth_module"))
+
+	if errors.Is(err, errUnlistedTarget) {
+		http.Error(w, fmt.Sprintf("forbidden target: %v", err), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !keep {
+		defer c.Close()
+	}
+
+	s, err := c.NewScrapeContext(r.Context(), scrapeDeadline(r, h.offset), r.URL.Query()["collect[]"])
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(h.labels, registry).MustRegister(s)
+
+	promhttp.HandlerFor(withFilter(withRenames(withNamespace(registry, h.namespace), h.renames), h.filter), promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+}
+
+// Close closes the collectors of the configured targets.
+func (h *ProbeHandler) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for key, c := range h.collectors {
+		c.Close()
+		delete(h.collectors, key)
+	}
+}
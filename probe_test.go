@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/florentchauveau/freeswitch_exporter/pkg/eslmock"
+)
+
+// authRecorder is an event socket rejecting every connection, recording the
+// authentication command of each.
+type authRecorder struct {
+	listener net.Listener
+	commands chan string
+}
+
+func newAuthRecorder(t *testing.T) *authRecorder {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &authRecorder{listener: listener, commands: make(chan string, 10)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			io.WriteString(conn, "Content-Type: auth/request\n\n")
+
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			r.commands <- strings.TrimSpace(command)
+
+			io.WriteString(conn, "Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+			conn.Close()
+		}
+	}()
+
+	return r
+}
+
+func (r *authRecorder) uri() string {
+	return "tcp://" + r.listener.Addr().String()
+}
+
+func newTestProbeHandler(t *testing.T, targets []Target, unlisted bool) *ProbeHandler {
+	t.Helper()
+
+	modules := map[string]AuthModule{"edge": {User: "exporter@edge.example.com", Password: stringList{"edge-secret"}}}
+
+	h := NewProbeHandler(time.Second, 0, "default-secret", collector.Options{Collectors: []string{"core"}}, targets, modules, unlisted, nil, collector.Namespace, nil, nil)
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+func probe(h http.Handler, target, module string) *httptest.ResponseRecorder {
+	query := url.Values{"target": {target}}
+
+	if len(module) != 0 {
+		query.Set("auth_module", module)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?"+query.Encode(), nil))
+
+	return w
+}
+
+func TestProbeConfiguredTarget(t *testing.T) {
+	server, err := eslmock.New("target-secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	h := newTestProbeHandler(t, []Target{{URI: server.URI(), Password: stringList{"target-secret"}}}, false)
+	w := probe(h, server.URI(), "")
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\nfreeswitch_up 1\n") {
+		t.Errorf("got %d, want freeswitch_up 1:\n%s", w.Code, w.Body.String())
+	}
+}
+
+func TestProbeUnlistedTarget(t *testing.T) {
+	recorder := newAuthRecorder(t)
+	listed := newAuthRecorder(t)
+
+	for _, test := range []struct {
+		name     string
+		unlisted bool
+		module   string
+		code     int
+		auth     string
+	}{
+		{name: "rejected", code: http.StatusForbidden},
+		{name: "auth module", module: "edge", code: http.StatusForbidden},
+		{name: "allowed", unlisted: true, code: http.StatusOK, auth: "auth"},
+		{name: "allowed with auth module", unlisted: true, module: "edge", code: http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestProbeHandler(t, []Target{{URI: listed.uri()}}, test.unlisted)
+
+			if w := probe(h, recorder.uri(), test.module); w.Code != test.code {
+				t.Errorf("got %d, want %d:\n%s", w.Code, test.code, w.Body.String())
+			}
+
+			select {
+			case auth := <-recorder.commands:
+				if auth != test.auth {
+					t.Errorf("got %q, want %q", auth, test.auth)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if len(test.auth) != 0 {
+					t.Errorf("no connection, want %q", test.auth)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeListedTargetCredentials(t *testing.T) {
+	listed := newAuthRecorder(t)
+	user := newAuthRecorder(t)
+	h := newTestProbeHandler(t, []Target{{URI: listed.uri()}, {URI: user.uri(), User: "exporter@target.example.com"}}, false)
+
+	for _, test := range []struct {
+		recorder *authRecorder
+		module   string
+		auth     string
+	}{
+		{listed, "", "auth default-secret"},
+		{listed, "edge", "userauth exporter@edge.example.com:edge-secret"},
+		{user, "", "userauth exporter@target.example.com:default-secret"},
+	} {
+		probe(h, test.recorder.uri(), test.module)
+
+		select {
+		case auth := <-test.recorder.commands:
+			if auth != test.auth {
+				t.Errorf("auth module %q: got %q, want %q", test.module, auth, test.auth)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("auth module %q: no connection", test.module)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pusher pushes the metrics of gather to a Pushgateway on an interval,
+// grouped by job and instance.
+type pusher struct {
+	pusher   *push.Pusher
+	interval time.Duration
+	logger   log.Logger
+
+	failures prometheus.Counter
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newPusher starts pushing to the Pushgateway at gatewayURL, whose user
+// information is used for basic authentication. Metrics are gathered with a
+// deadline of timeout.
+func newPusher(gatewayURL, job, instance string, interval, timeout time.Duration, gather func(deadline time.Time) ([]*dto.MetricFamily, error), logger log.Logger) (*pusher, error) {
+	u, err := url.Parse(gatewayURL)
+
+	if err != nil {
+		return nil, fmt.Errorf("pushgateway url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("pushgateway url %q: http or https is expected", gatewayURL)
+	}
+
+	// the Pushgateway rejects samples with a timestamp
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gather(time.Now().Add(timeout))
+
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				m.TimestampMs = nil
+			}
+		}
+
+		return families, err
+	})
+
+	p := &pusher{
+		interval: interval,
+		logger:   logger,
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: "exporter",
+			Name:      "push_failures_total",
+			Help:      "Number of failed pushes to the Pushgateway.",
+		}),
+		stop: make(chan struct{}),
+	}
+
+	password, _ := u.User.Password()
+	username := u.User.Username()
+	u.User = nil
+
+	p.pusher = push.New(u.String(), job).
+		Gatherer(gatherer).
+		Grouping("instance", instance).
+		Client(&http.Client{Timeout: 2 * timeout})
+
+	if len(username) != 0 {
+		p.pusher.BasicAuth(username, password)
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p, nil
+}
+
+func (p *pusher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// PUT replaces the metrics of the group, so that series that are gone
+		// are not pushed forever
+		if err := p.pusher.Push(); err != nil {
+			level.Error(p.logger).Log("msg", "Cannot push to the Pushgateway", "err", err)
+			p.failures.Inc()
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *pusher) Describe(ch chan<- *prometheus.Desc) {
+	p.failures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *pusher) Collect(ch chan<- prometheus.Metric) {
+	p.failures.Collect(ch)
+}
+
+// Close stops pushing.
+func (p *pusher) Close() {
+	close(p.stop)
+	p.wg.Wait()
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"go.opentelemetry.io/otel"
+)
+
+// exporter holds the collectors built from one configuration, and the
+// handler serving them.
+type exporter struct {
+	collectors []*collector.Collector
+	probe      *ProbeHandler
+	handler    http.Handler
+
+	// config the collectors of the scrape URIs and discovered instances are
+	// created with, and those of them taken over from the previous exporter,
+	// which keeps closing them until the reload completes
+	config collectorConfig
+	reused map[*collector.Collector]bool
+
+	// outbound replaces the scrape URIs if not nil, it is kept across reloads
+	outbound *collector.OutboundServer
+
+	// discovery replaces the scrape URIs if not nil
+	discovery *discovery
+
+	// registry holds the metrics of the exporter itself
+	registry *prometheus.Registry
+
+	// remoteWrite pushes the metrics if not nil
+	remoteWrite *remoteWriter
+
+	// pusher pushes the metrics to a Pushgateway if not nil
+	pusher *pusher
+
+	// networks allowed to reach the web endpoints, see --web.allowed-cidrs
+	networks []*net.IPNet
+
+	// labels added to the freeswitch metrics, see --metrics.const-label, and
+	// namespace of their names
+	labels    prometheus.Labels
+	namespace string
+
+	// renames of the metric_renames section, and filter dropping metrics by
+	// name if not nil, see --metrics.include and --metrics.exclude
+	renames []MetricRename
+	filter  *metricFilter
+
+	// cluster labeling the aggregates of the metrics of all the instances,
+	// none if empty, see --metrics.cluster
+	cluster string
+}
+
+// collectorConfig is the configuration of a collector, besides its URI.
+type collectorConfig struct {
+	timeout  time.Duration
+	password string
+	options  collector.Options
+}
+
+// newOptions returns the collector options and the password of the flags,
+// the other passwords being the fallback passwords of the options.
+func newOptions(f *flags, config *Config, logger log.Logger) (collector.Options, string, error) {
+	options := collector.Options{
+		User:               *f.user,
+		Heartbeat:          *f.heartbeat,
+		Events:             *f.events,
+		Exemplars:          *f.exemplars,
+		Codecs:             *f.codecs,
+		ShortCallThreshold: *f.shortCalls,
+		LowMOSThreshold:    *f.lowMOS,
+		CallsPeakWindows:   *f.callsPeak,
+		ProbeCall:          *f.probeCall,
+		ProbeCallInterval:  *f.probeInterval,
+		ProbeCallTimeout:   *f.probeTimeout,
+		EventBufferSize:    *f.eventBuffer,
+		CertsDir:           *f.certsDir,
+		MaxConnections:     *f.connections,
+		PipelineDepth:      *f.pipeline,
+		CommandTimeout:     *f.commandTimeout,
+		Proxy:              *f.proxyURL,
+		SSHKeyFile:         *f.sshKeyFile,
+		SSHKnownHostsFile:  *f.sshKnownHosts,
+		Retries:            *f.retries,
+		RetryBackoff:       *f.retryBackoff,
+		MaxStaleness:       *f.maxStaleness,
+		TimeSyncTolerance:  *f.timeSync,
+		ChannelVariables:   config.ChannelVariables,
+		Commands:           config.Commands,
+		EmergencyPatterns:  config.EmergencyPatterns,
+		TLSCertificates:    config.TLSCertificates,
+		Role:               config.Role,
+		RecordResponses:    *f.eslDebugPath != "",
+		Logger:             logger,
+		TracerProvider:     otel.GetTracerProvider(),
+	}
+
+	for _, info := range collector.Collectors() {
+		if *f.collectors[info.Name] {
+			options.Collectors = append(options.Collectors, info.Name)
+		}
+	}
+
+	// none enabled is not the defaults
+	if options.Collectors == nil {
+		options.Collectors = []string{}
+	}
+
+	options.CacheTTLs = make(map[string]time.Duration)
+
+	for name, value := range *f.cacheTTLs {
+		ttl, err := time.ParseDuration(value)
+
+		if err != nil {
+			return options, "", fmt.Errorf("cache TTL of collector %s: %w", name, err)
+		}
+
+		options.CacheTTLs[name] = ttl
+	}
+
+	passwords := *f.password
+
+	if len(*f.passwordFile) != 0 {
+		passwords = nil
+
+		for _, file := range *f.passwordFile {
+			password, err := readPasswordFile(file)
+
+			if err != nil {
+				return options, "", err
+			}
+
+			passwords = append(passwords, password)
+		}
+	}
+
+	options.FallbackPasswords = passwords[1:]
+
+	return options, passwords[0], nil
+}
+
+// newExporter returns the exporter of f and config. The collectors of previous
+// (nil on startup) whose URI and configuration did not change are reused, so
+// that their connections and the metrics derived from events are kept.
+func newExporter(f *flags, config *Config, cdr *CDRHandler, outbound *collector.OutboundServer, previous *exporter, logger log.Logger) (*exporter, error) {
+	options, password, err := newOptions(f, config, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := constLabels(*f.constLabels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := validateNamespace(*f.namespace)
+
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := parseCIDRs(*f.allowedCIDRs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := newMetricFilter(*f.include, *f.exclude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := labels["cluster"]; ok && len(*f.cluster) != 0 {
+		return nil, errors.New(`constant label "cluster": reserved name with --metrics.cluster`)
+	}
+
+	e := &exporter{outbound: outbound, networks: networks, labels: labels, namespace: namespace, renames: config.MetricRenames, filter: filter, cluster: *f.cluster}
+	e.config = collectorConfig{timeout: *f.timeout, password: password, options: options}
+	e.reused = make(map[*collector.Collector]bool)
+
+	// collectors are only reused while e is created, not by later discoveries
+	var reuseMutex sync.Mutex
+	reusing := true
+
+	defer func() {
+		reuseMutex.Lock()
+		reusing = false
+		reuseMutex.Unlock()
+	}()
+
+	create := func(uri string) (*collector.Collector, error) {
+		reuseMutex.Lock()
+		defer reuseMutex.Unlock()
+
+		if c := previous.collector(uri, e.config); c != nil && reusing {
+			e.reused[c] = true
+			return c, nil
+		}
+
+		return collector.New(uri, *f.timeout, password, options)
+	}
+
+	if outbound == nil && (*f.scrapeSRV != "" || *f.targetsFile != "") {
+		if e.discovery, err = newDiscovery(*f.scrapeSRV, *f.targetsFile, *f.discovery, create, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, value := range *f.scrapeURIs {
+		if outbound != nil || e.discovery != nil {
+			break
+		}
+
+		for _, uri := range strings.Split(value, ",") {
+			c, err := create(strings.TrimSpace(uri))
+
+			if err != nil {
+				e.Close()
+				return nil, err
+			}
+
+			e.collectors = append(e.collectors, c)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	e.registry = registry
+	registry.MustRegister(version.NewCollector("freeswitch_exporter"))
+
+	if !*f.noSelfMetrics {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+
+	// requests beyond the limit would wait for the scrape in progress
+	limiter := newRequestLimiter(*f.maxRequests)
+
+	if limiter != nil {
+		registry.MustRegister(limiter)
+	}
+
+	mux := http.NewServeMux()
+
+	if *f.cdrPath != "" {
+		prometheus.WrapRegistererWith(labels, registry).MustRegister(cdr)
+		mux.Handle(*f.cdrPath, cdr)
+	}
+
+	if *f.eslDebugPath != "" {
+		mux.Handle(*f.eslDebugPath, eslDebugHandler(e))
+	}
+
+	if *f.pprof {
+		mux.Handle("/debug/pprof/", pprofHandler())
+	}
+
+	if *f.probePath != "" {
+		e.probe = NewProbeHandler(*f.timeout, *f.timeoutOffset, password, options, config.Targets, config.AuthModules, *f.probeUnlisted, labels, namespace, config.MetricRenames, filter)
+		mux.Handle(*f.probePath, limiter.handler(traceHandler("probe", e.probe)))
+
+		if *f.sdPath != "" {
+			mux.Handle(*f.sdPath, sdHandler(config.Targets, *f.probePath))
+		}
+	}
+
+	// the collectors are registered on every scrape, with the deadline and
+	// the span of the request
+	gatherRequest := func(r *http.Request) (prometheus.Gatherer, error) {
+		return e.gatherer(r.Context(), scrapeDeadline(r, *f.timeoutOffset), r.URL.Query()["collect[]"])
+	}
+
+	if *f.jsonPath != "" {
+		mux.Handle(*f.jsonPath, limiter.handler(traceHandler("json", jsonHandler(gatherRequest))))
+	}
+
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherer, err := gatherRequest(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+	})
+
+	handler := limiter.handler(traceHandler("metrics", metrics))
+
+	// promhttp_* count the requests of the metrics endpoint
+	if !*f.noSelfMetrics {
+		handler = promhttp.InstrumentMetricHandler(registry, handler)
+	}
+
+	mux.Handle(*f.metricsPath, handler)
+	e.handler = allowCIDRs(networks, mux, logger)
+
+	gather := func(deadline time.Time) ([]*dto.MetricFamily, error) {
+		gatherer, err := e.gatherer(context.Background(), deadline, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return gatherer.Gather()
+	}
+
+	if config.RemoteWrite != nil && !*f.once {
+		if e.remoteWrite, err = newRemoteWriter(*config.RemoteWrite, gather, logger); err != nil {
+			e.Close()
+			return nil, err
+		}
+
+		registry.MustRegister(e.remoteWrite)
+	}
+
+	if len(*f.pushURL) != 0 && !*f.once {
+		instance := *f.pushInstance
+
+		if len(instance) == 0 {
+			if instance, err = os.Hostname(); err != nil {
+				e.Close()
+				return nil, fmt.Errorf("push instance: %w", err)
+			}
+		}
+
+		if e.pusher, err = newPusher(*f.pushURL, *f.pushJob, instance, *f.pushInterval, *f.timeout, gather, logger); err != nil {
+			e.Close()
+			return nil, err
+		}
+
+		registry.MustRegister(e.pusher)
+	}
+
+	return e, nil
+}
+
+// instances returns the collectors of e by fs_instance label: their URI, the
+// switchname of outbound instances, or nothing for a single scrape URI.
+// Discovered instances are always labeled, as their number changes.
+func (e *exporter) instances() map[string]*collector.Collector {
+	if e.outbound != nil {
+		return e.outbound.Collectors()
+	}
+
+	if e.discovery != nil {
+		return e.discovery.Collectors()
+	}
+
+	instances := make(map[string]*collector.Collector)
+
+	if len(e.collectors) == 1 {
+		instances[""] = e.collectors[0]
+		return instances
+	}
+
+	for _, c := range e.collectors {
+		instances[c.URI] = c
+	}
+
+	return instances
+}
+
+// gatherer returns the metrics of the exporter, and of a scrape of its
+// collectors with ctx, deadline and collect (see newScrape), in its namespace,
+// renamed and filtered.
+func (e *exporter) gatherer(ctx context.Context, deadline time.Time, collect []string) (prometheus.Gatherer, error) {
+	scrape, err := e.newScrape(ctx, deadline, collect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.expose(prometheus.Gatherers{e.registry, scrape}), nil
+}
+
+// expose returns a gatherer of the metrics of g as they are exposed: along
+// with their cluster aggregates, in the namespace of e, renamed and filtered.
+func (e *exporter) expose(g prometheus.Gatherer) prometheus.Gatherer {
+	return withFilter(withRenames(withNamespace(withCluster(g, e.cluster, e.labels), e.namespace), e.renames), e.filter)
+}
+
+// newScrape returns a registry scraping the collectors of e until deadline,
+// their metrics labeled with their instance and the constant labels. The
+// spans of the scrapes are children of the span in ctx.
+func (e *exporter) newScrape(ctx context.Context, deadline time.Time, collect []string) (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+
+	for instance, c := range e.instances() {
+		s, err := c.NewScrapeContext(ctx, deadline, collect)
+
+		if err != nil {
+			return nil, err
+		}
+
+		labels := prometheus.Labels{}
+
+		for name, value := range e.labels {
+			labels[name] = value
+		}
+
+		if len(instance) != 0 {
+			labels["fs_instance"] = instance
+		}
+
+		prometheus.WrapRegistererWith(labels, registry).MustRegister(s)
+	}
+
+	return registry, nil
+}
+
+// scrapeDeadline returns the deadline of the scrape request r, its Prometheus
+// scrape timeout minus offset, or zero if it has none.
+func scrapeDeadline(r *http.Request, offset time.Duration) time.Time {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+
+	if timeout <= 0 {
+		// leave some time for the scrape to fail
+		timeout = time.Duration(seconds * float64(time.Second) / 2)
+	}
+
+	return time.Now().Add(timeout)
+}
+
+// collector returns the collector of the scrape URI or discovered instance
+// uri of e if it was created with config, nil otherwise or if e is nil.
+func (e *exporter) collector(uri string, config collectorConfig) *collector.Collector {
+	if e == nil || e.outbound != nil || !reflect.DeepEqual(e.config, config) {
+		return nil
+	}
+
+	if e.discovery != nil {
+		return e.discovery.Collectors()[uri]
+	}
+
+	for _, c := range e.collectors {
+		if c.URI == uri {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Close stops pushing the metrics, and closes the connections of the
+// collectors, but those reused from the previous exporter until the reload
+// completes.
+func (e *exporter) Close() {
+	e.close(e.reused)
+}
+
+// close closes e, but the collectors in keep.
+func (e *exporter) close(keep map[*collector.Collector]bool) {
+	if e.remoteWrite != nil {
+		e.remoteWrite.Close()
+	}
+
+	if e.pusher != nil {
+		e.pusher.Close()
+	}
+
+	for _, c := range e.collectors {
+		if !keep[c] {
+			c.Close()
+		}
+	}
+
+	if e.discovery != nil {
+		e.discovery.close(keep)
+	}
+
+	if e.probe != nil {
+		e.probe.Close()
+	}
+}
+
+// reloader serves HTTP requests with the exporter of the current
+// configuration, and replaces it when the configuration is reloaded. The web
+// listener and the logger are kept, so changes to the listen address, web
+// configuration and log flags need a restart.
+type reloader struct {
+	args   []string
+	logger log.Logger
+
+	// CDR counters and outbound connections are kept across reloads
+	cdr      *CDRHandler
+	outbound *collector.OutboundServer
+
+	mutex   sync.RWMutex
+	current *exporter
+	closed  bool
+
+	// reloads are serialized, each reusing the collectors of the previous
+	reloadMutex sync.Mutex
+}
+
+func newReloader(args []string, f *flags, config *Config, logger log.Logger) (*reloader, error) {
+	r := &reloader{
+		args:   args,
+		logger: logger,
+		cdr:    NewCDRHandler(*f.cdrMaxGateways, logger),
+	}
+
+	if *f.outboundAddress != "" {
+		options, _, err := newOptions(f, config, logger)
+
+		if err != nil {
+			return nil, err
+		}
+
+		networks, err := parseCIDRs(*f.outboundCIDRs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if r.outbound, err = collector.NewOutboundServer(*f.outboundAddress, networks, *f.outboundMax, *f.outboundIdle, *f.timeout, options); err != nil {
+			return nil, err
+		}
+	}
+
+	e, err := newExporter(f, config, r.cdr, r.outbound, nil, logger)
+
+	if err != nil {
+		if r.outbound != nil {
+			r.outbound.Close()
+		}
+
+		return nil, err
+	}
+
+	r.current = e
+
+	return r, nil
+}
+
+// reload parses the command line and the configuration file again, and
+// replaces the exporter. The current exporter is kept on error. The
+// collectors whose configuration did not change are kept.
+func (r *reloader) reload() error {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+
+	app, f := newApp()
+	config, err := parseFlags(app, r.args)
+
+	if err != nil {
+		return err
+	}
+
+	r.mutex.RLock()
+	current := r.current
+	r.mutex.RUnlock()
+
+	e, err := newExporter(f, config, r.cdr, r.outbound, current, r.logger)
+
+	if err != nil {
+		return err
+	}
+
+	// requests hold the read lock while being served, so that the previous
+	// exporter is no longer used once the lock is acquired
+	r.mutex.Lock()
+
+	if r.closed {
+		r.mutex.Unlock()
+		e.Close()
+		return errors.New("exporter is shutting down")
+	}
+
+	previous := r.current
+	r.current = e
+
+	// the reused collectors are now closed by e
+	reused := e.reused
+	e.reused = nil
+	r.mutex.Unlock()
+
+	previous.close(reused)
+
+	level.Info(r.logger).Log("msg", "Configuration reloaded")
+
+	return nil
+}
+
+// Close closes the current exporter, once the requests being served are done.
+func (r *reloader) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	r.current.Close()
+
+	if r.outbound != nil {
+		r.outbound.Close()
+	}
+}
+
+// waitConnected returns once the collector of the current exporter is
+// connected to FreeSWITCH, or once r is closed.
+func (r *reloader) waitConnected() {
+	for {
+		r.mutex.RLock()
+		collectors, closed := r.current.instances(), r.closed
+		r.mutex.RUnlock()
+
+		if closed {
+			return
+		}
+
+		var err error
+
+		for _, c := range collectors {
+			if err = c.Connect(); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			return
+		}
+
+		level.Debug(r.logger).Log("msg", "Waiting for FreeSWITCH", "err", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// verify connects the collectors of the current exporter to FreeSWITCH, and
+// returns the first error, e.g. a rejected password.
+func (r *reloader) verify() error {
+	r.mutex.RLock()
+	collectors := r.current.instances()
+	r.mutex.RUnlock()
+
+	for _, c := range collectors {
+		if err := c.Connect(); err != nil {
+			return fmt.Errorf("%s: %w", c.URI, err)
+		}
+	}
+
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	r.current.handler.ServeHTTP(w, req)
+}
+
+// reloadHandler returns the handler of /-/reload, reloading the configuration
+// on POST requests from the networks allowed by the current configuration.
+func (r *reloader) reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.RLock()
+		ok := allowed(r.current.networks, req)
+		r.mutex.RUnlock()
+
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			http.Error(w, "This endpoint requires a POST or PUT request.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// constLabels returns the constant labels of --metrics.const-label.
+func constLabels(values map[string]string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+
+	for name, value := range values {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("constant label %q: invalid name", name)
+		}
+
+		if name == "fs_instance" {
+			return nil, fmt.Errorf("constant label %q: reserved name", name)
+		}
+
+		labels[name] = value
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/eslmock"
+	"github.com/go-kit/log"
+)
+
+func newTestReloader(t *testing.T, args []string) *reloader {
+	t.Helper()
+
+	app, f := newApp()
+	config, err := parseFlags(app, args)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newReloader(args, f, config, log.NewNopLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func TestReloadKeepsCollectors(t *testing.T) {
+	server, err := eslmock.New("ClueCon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	r := newTestReloader(t, []string{"--freeswitch.scrape-uri=" + server.URI(), "--freeswitch.password=ClueCon"})
+	c := r.current.collectors[0]
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	scrape()
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.current.collectors[0] != c {
+		t.Error("collector replaced, its configuration did not change")
+	}
+
+	// the counters of the collector go on
+	if body := scrape(); !strings.Contains(body, "\nfreeswitch_up 1\n") || !strings.Contains(body, "\nfreeswitch_exporter_total_scrapes 2\n") {
+		t.Errorf("got metrics, want freeswitch_up 1 and 2 scrapes:\n%s", body)
+	}
+
+	r.args = append(r.args, "--freeswitch.timeout=3s")
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.current.collectors[0] == c {
+		t.Error("collector kept, its configuration changed")
+	}
+}
+
+func TestReloadKeepsDiscoveredCollectors(t *testing.T) {
+	var uris []string
+
+	for i := 0; i < 2; i++ {
+		server, err := eslmock.New("ClueCon")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer server.Close()
+
+		uris = append(uris, server.URI())
+	}
+
+	file := filepath.Join(t.TempDir(), "targets")
+
+	if err := os.WriteFile(file, []byte(uris[0]+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReloader(t, []string{"--freeswitch.targets-file=" + file})
+	first := r.current.instances()[uris[0]]
+
+	if err := os.WriteFile(file, []byte(uris[0]+"\n"+uris[1]+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	instances := r.current.instances()
+
+	if instances[uris[0]] != first {
+		t.Errorf("collector of %s replaced, its configuration did not change", uris[0])
+	}
+
+	if instances[uris[1]] == nil {
+		t.Errorf("%s not discovered", uris[1])
+	}
+}
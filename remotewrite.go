@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/florentchauveau/freeswitch_exporter/pkg/collector"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteConfig is the remote_write section of the configuration file:
+// the metrics are collected every Interval and pushed to URL with the
+// Prometheus remote write protocol.
+type RemoteWriteConfig struct {
+	URL      string         `yaml:"url"`
+	Interval model.Duration `yaml:"interval"`
+	Timeout  model.Duration `yaml:"timeout"`
+
+	// collections kept while URL cannot be reached, the oldest being dropped
+	QueueCapacity int `yaml:"queue_capacity"`
+
+	// attempts to push a collection after a server or connection error,
+	// waiting from MinBackoff to MaxBackoff between them
+	MaxRetries int            `yaml:"max_retries"`
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+
+	// labels added to all the series, which have no job and instance labels
+	ExternalLabels map[string]string `yaml:"external_labels"`
+
+	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
+}
+
+var remoteWritePendingDesc = prometheus.NewDesc(collector.Namespace+"_exporter_remote_write_pending_collections", "Number of collections waiting to be pushed to the remote write endpoint.", nil, nil)
+
+var defaultRemoteWriteConfig = RemoteWriteConfig{
+	Interval:         model.Duration(15 * time.Second),
+	Timeout:          model.Duration(10 * time.Second),
+	QueueCapacity:    240,
+	MaxRetries:       10,
+	MinBackoff:       model.Duration(500 * time.Millisecond),
+	MaxBackoff:       model.Duration(5 * time.Minute),
+	HTTPClientConfig: config.DefaultHTTPClientConfig,
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, setting the defaults.
+func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = defaultRemoteWriteConfig
+
+	type plain RemoteWriteConfig
+
+	return unmarshal((*plain)(c))
+}
+
+func (c *RemoteWriteConfig) validate() error {
+	u, err := url.Parse(c.URL)
+
+	if err != nil {
+		return fmt.Errorf("remote write url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("remote write url %q: http or https is expected", c.URL)
+	}
+
+	if c.Interval <= 0 || c.Timeout <= 0 || c.QueueCapacity < 1 || c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		return errors.New("remote write: interval, timeout, queue_capacity and backoffs must be positive")
+	}
+
+	for name := range c.ExternalLabels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+			return fmt.Errorf("remote write external label %q: invalid name", name)
+		}
+	}
+
+	return c.HTTPClientConfig.Validate()
+}
+
+// remoteWriteBatch is a collection, encoded as a remote write request.
+type remoteWriteBatch struct {
+	request []byte
+	samples int
+}
+
+// remoteWriter collects the metrics of gather on an interval and pushes them
+// to the remote write endpoint, queueing and retrying the collections that
+// could not be pushed.
+type remoteWriter struct {
+	config RemoteWriteConfig
+	gather func(deadline time.Time) ([]*dto.MetricFamily, error)
+	client *http.Client
+	logger log.Logger
+
+	queue chan remoteWriteBatch
+
+	sent    prometheus.Counter
+	failed  prometheus.Counter
+	dropped prometheus.Counter
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newRemoteWriter(c RemoteWriteConfig, gather func(deadline time.Time) ([]*dto.MetricFamily, error), logger log.Logger) (*remoteWriter, error) {
+	client, err := config.NewClientFromConfig(c.HTTPClientConfig, "remote_write")
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := &remoteWriter{
+		config: c,
+		gather: gather,
+		client: client,
+		logger: logger,
+		queue:  make(chan remoteWriteBatch, c.QueueCapacity),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: "exporter",
+			Name:      "remote_write_sent_samples_total",
+			Help:      "Number of samples pushed to the remote write endpoint.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: "exporter",
+			Name:      "remote_write_failed_samples_total",
+			Help:      "Number of samples rejected by the remote write endpoint, or not pushed after all retries.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: "exporter",
+			Name:      "remote_write_dropped_samples_total",
+			Help:      "Number of samples dropped because the remote write queue was full.",
+		}),
+		stop: make(chan struct{}),
+	}
+
+	w.wg.Add(2)
+	go w.collect()
+	go w.push()
+
+	return w, nil
+}
+
+// Describe implements prometheus.Collector.
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(w, ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	ch <- w.sent
+	ch <- w.failed
+	ch <- w.dropped
+	ch <- prometheus.MustNewConstMetric(remoteWritePendingDesc, prometheus.GaugeValue, float64(len(w.queue)))
+}
+
+// collect queues a collection every interval, dropping the oldest one if the
+// queue is full.
+func (w *remoteWriter) collect() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(w.config.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		families, err := w.gather(now.Add(time.Duration(w.config.Timeout)))
+
+		if err != nil {
+			level.Warn(w.logger).Log("msg", "Collection for remote write failed", "err", err)
+		}
+
+		batch := encodeWriteRequest(families, now, w.config.ExternalLabels)
+
+		for queued := false; !queued; {
+			select {
+			case w.queue <- batch:
+				queued = true
+			default:
+				select {
+				case old := <-w.queue:
+					w.dropped.Add(float64(old.samples))
+				default:
+				}
+			}
+		}
+	}
+}
+
+// push sends the queued collections in order.
+func (w *remoteWriter) push() {
+	defer w.wg.Done()
+
+	for {
+		var batch remoteWriteBatch
+
+		select {
+		case <-w.stop:
+			return
+		case batch = <-w.queue:
+		}
+
+		backoff := time.Duration(w.config.MinBackoff)
+
+		for attempt := 0; ; attempt++ {
+			retry, err := w.send(batch)
+
+			if err == nil {
+				w.sent.Add(float64(batch.samples))
+				break
+			}
+
+			if !retry || attempt >= w.config.MaxRetries {
+				level.Error(w.logger).Log("msg", "Cannot push to remote write endpoint, dropping samples", "samples", batch.samples, "err", err)
+				w.failed.Add(float64(batch.samples))
+				break
+			}
+
+			level.Warn(w.logger).Log("msg", "Cannot push to remote write endpoint, retrying", "retry_in", backoff, "err", err)
+
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > time.Duration(w.config.MaxBackoff) {
+				backoff = time.Duration(w.config.MaxBackoff)
+			}
+		}
+	}
+}
+
+// send pushes batch, and tells if it can be pushed again after a failure:
+// on connection errors, server errors and rate limiting.
+func (w *remoteWriter) send(batch remoteWriteBatch) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.config.Timeout))
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(batch.request))
+
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", "freeswitch_exporter/"+version.Version)
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	response, err := w.client.Do(request)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode/100 == 2 {
+		io.Copy(io.Discard, response.Body)
+		return false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	err = fmt.Errorf("server returned %s: %s", response.Status, bytes.TrimSpace(body))
+
+	return response.StatusCode/100 == 5 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close stops collecting and pushing, the queued collections are lost.
+func (w *remoteWriter) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// encodeWriteRequest encodes families as a snappy compressed remote write
+// request (prometheus.WriteRequest protobuf message), the samples without
+// timestamp being stamped with now.
+func encodeWriteRequest(families []*dto.MetricFamily, now time.Time, external map[string]string) remoteWriteBatch {
+	var request []byte
+	var samples int
+
+	timestamp := now.UnixMilli()
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel())+len(external))
+
+			for name, value := range external {
+				labels[name] = value
+			}
+
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			ts := timestamp
+
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(suffix string, value float64, extra ...string) {
+				series := make(map[string]string, len(labels)+2)
+
+				for name, v := range labels {
+					series[name] = v
+				}
+
+				for i := 0; i+1 < len(extra); i += 2 {
+					series[extra[i]] = extra[i+1]
+				}
+
+				series[model.MetricNameLabel] = family.GetName() + suffix
+				request = protowire.AppendTag(request, 1, protowire.BytesType)
+				request = protowire.AppendBytes(request, encodeTimeSeries(series, value, ts))
+				samples++
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add("", q.GetValue(), model.QuantileLabel, strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64))
+				}
+
+				add("_sum", m.GetSummary().GetSampleSum())
+				add("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				for _, b := range m.GetHistogram().GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), model.BucketLabel, strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64))
+				}
+
+				add("_bucket", float64(m.GetHistogram().GetSampleCount()), model.BucketLabel, "+Inf")
+				add("_sum", m.GetHistogram().GetSampleSum())
+				add("_count", float64(m.GetHistogram().GetSampleCount()))
+			default:
+				add("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+
+	return remoteWriteBatch{request: snappy.Encode(nil, request), samples: samples}
+}
+
+// encodeTimeSeries encodes a prometheus.TimeSeries message of one sample,
+// with labels sorted by name as the protocol requires.
+func encodeTimeSeries(labels map[string]string, value float64, timestamp int64) []byte {
+	names := make([]string, 0, len(labels))
+
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var series []byte
+
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, labels[name])
+
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(timestamp))
+
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+
+	return series
+}
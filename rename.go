@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// MetricRename is an entry of the metric_renames section of the configuration
+// file: the metrics named From are renamed to To if set, and their labels
+// renamed as listed in Labels (old name to new name), e.g. to keep the
+// dashboards of another exporter working during a migration.
+type MetricRename struct {
+	From   string            `yaml:"from"`
+	To     string            `yaml:"to"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+func (def MetricRename) validate() error {
+	if !model.IsValidMetricName(model.LabelValue(def.From)) {
+		return fmt.Errorf("metric rename %q: invalid metric name", def.From)
+	}
+
+	if len(def.To) != 0 && !model.IsValidMetricName(model.LabelValue(def.To)) {
+		return fmt.Errorf("metric rename %q: invalid metric name %q", def.From, def.To)
+	}
+
+	if len(def.To) == 0 && len(def.Labels) == 0 {
+		return fmt.Errorf("metric rename %q: to or labels is required", def.From)
+	}
+
+	for old, name := range def.Labels {
+		for _, label := range []string{old, name} {
+			if !model.LabelName(label).IsValid() || label == model.MetricNameLabel {
+				return fmt.Errorf("metric rename %q: invalid label name %q", def.From, label)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withRenames returns a gatherer renaming the metrics of g and their labels
+// as listed in renames, by their exposed name, or g if renames is empty. The
+// metrics renamed to the name of other metrics of the same type are merged.
+func withRenames(g prometheus.Gatherer, renames []MetricRename) prometheus.Gatherer {
+	if len(renames) == 0 {
+		return g
+	}
+
+	defs := make(map[string]MetricRename)
+
+	for _, def := range renames {
+		defs[def.From] = def
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		errs := prometheus.MultiError{}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		merged := make(map[string]*dto.MetricFamily)
+		renamed := families[:0]
+
+		for _, family := range families {
+			if def, ok := defs[family.GetName()]; ok {
+				renameFamily(family, def)
+			}
+
+			name := family.GetName()
+
+			if other, ok := merged[name]; ok {
+				if other.GetType() != family.GetType() {
+					errs = append(errs, fmt.Errorf("metric rename: %s is both a %s and a %s", name, other.GetType(), family.GetType()))
+					continue
+				}
+
+				other.Metric = append(other.Metric, family.Metric...)
+				continue
+			}
+
+			merged[name] = family
+			renamed = append(renamed, family)
+		}
+
+		sort.Slice(renamed, func(i, j int) bool {
+			return renamed[i].GetName() < renamed[j].GetName()
+		})
+
+		return renamed, errs.MaybeUnwrap()
+	})
+}
+
+// renameFamily renames family and the labels of its metrics as def lists.
+// The labels are copied before being renamed, as their pairs are shared with
+// the descriptors of the metrics and with the metrics sent again from cache.
+func renameFamily(family *dto.MetricFamily, def MetricRename) {
+	if len(def.To) != 0 {
+		name := def.To
+		family.Name = &name
+	}
+
+	if len(def.Labels) == 0 {
+		return
+	}
+
+	for _, m := range family.Metric {
+		labels := make([]*dto.LabelPair, len(m.Label))
+
+		for i, pair := range m.Label {
+			name, value := pair.GetName(), pair.GetValue()
+
+			if renamed, ok := def.Labels[name]; ok {
+				name = renamed
+			}
+
+			labels[i] = &dto.LabelPair{Name: &name, Value: &value}
+		}
+
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].GetName() < labels[j].GetName()
+		})
+
+		m.Label = labels
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// constCollector sends the same metrics, whose labels are those of their
+// descriptors, on every collection, as the collectors do from cache.
+type constCollector []prometheus.Metric
+
+func (c constCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c {
+		ch <- metric.Desc()
+	}
+}
+
+func (c constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range c {
+		ch <- metric
+	}
+}
+
+func TestRenamesConcurrentGather(t *testing.T) {
+	desc := prometheus.NewDesc("freeswitch_current_calls", "Number of calls active", []string{"profile"}, prometheus.Labels{"instance_name": "edge1"})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(constCollector{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 3, "internal")})
+
+	renamed := withRenames(registry, []MetricRename{{
+		From:   "freeswitch_current_calls",
+		To:     "freeswitch_calls",
+		Labels: map[string]string{"instance_name": "node", "profile": "sip_profile"},
+	}})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				families, err := renamed.Gather()
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				labels := families[0].Metric[0].Label
+
+				if len(labels) != 2 || labels[0].GetName() != "node" || labels[1].GetName() != "sip_profile" {
+					t.Errorf("got labels %v, want node and sip_profile", labels)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	families, err := registry.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := families[0].Metric[0].Label
+
+	if len(labels) != 2 || labels[0].GetName() != "instance_name" || labels[1].GetName() != "profile" {
+		t.Errorf("renames changed the labels of the collected metric to %v", labels)
+	}
+}
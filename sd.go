@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// sdGroup is a target group of the Prometheus HTTP service discovery.
+type sdGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves the targets of the configuration file as Prometheus HTTP
+// service discovery groups, to be scraped through the probe endpoint at
+// probePath: the address of each group is the one the exporter was reached
+// at, the URI of the target being its target parameter and instance label.
+func sdHandler(targets []Target, probePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groups := make([]sdGroup, 0, len(targets))
+
+		for _, target := range targets {
+			labels := map[string]string{
+				"__metrics_path__": probePath,
+				"__param_target":   target.URI,
+				"instance":         target.URI,
+			}
+
+			if r.TLS != nil {
+				labels["__scheme__"] = "https"
+			}
+
+			for name, value := range target.Labels {
+				labels[name] = value
+			}
+
+			groups = append(groups, sdGroup{Targets: []string{r.Host}, Labels: labels})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemdListener returns the first socket passed by systemd socket
+// activation, or nil if there is none.
+func systemdListener() (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+
+	if pid != os.Getpid() || fds < 1 {
+		return nil, nil
+	}
+
+	// the passed file descriptors start at 3, they are not passed to children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(3, "systemd socket")
+	defer file.Close()
+
+	return net.FileListener(file)
+}
+
+// sdNotify sends state (e.g. READY=1) to the service manager, if the exporter
+// is run by systemd with Type=notify.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+
+	if len(name) == 0 {
+		return nil
+	}
+
+	// abstract socket
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
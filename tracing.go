@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/prometheus/common/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTracerProvider returns a tracer provider exporting the spans sampled
+// with ratio to the OTLP/HTTP endpoint, e.g. http://otel-collector:4318. The
+// OTEL_EXPORTER_OTLP_* environment variables (e.g. headers) also apply.
+func newTracerProvider(endpoint string, ratio float64) (*sdktrace.TracerProvider, error) {
+	u, err := parseTracing(endpoint, ratio)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	if len(u.Path) != 0 && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+
+	// the exporter connects on the first export
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+
+	if err != nil {
+		return nil, fmt.Errorf("tracing endpoint: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("freeswitch_exporter"),
+			semconv.ServiceVersionKey.String(version.Version),
+		)),
+	), nil
+}
+
+// parseTracing returns the URL of the OTLP/HTTP endpoint, checking it and the
+// sampling ratio.
+func parseTracing(endpoint string, ratio float64) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+
+	if err != nil {
+		return nil, fmt.Errorf("tracing endpoint: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("tracing endpoint %q: http or https is expected", endpoint)
+	}
+
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("tracing sampling ratio %v: between 0 and 1 is expected", ratio)
+	}
+
+	return u, nil
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// traceHandler serves the requests with next in a span named name, a child
+// of the span of the traceparent header if any. Its attributes include the
+// Prometheus scrape timeout, to match traces with scrapes.
+func traceHandler(name string, next http.Handler) http.Handler {
+	tracer := otel.Tracer("github.com/florentchauveau/freeswitch_exporter")
+	propagator := propagation.TraceContext{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+			attribute.String("prometheus.scrape_timeout", r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")),
+		))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+
+		if recorder.status >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, strconv.Itoa(recorder.status))
+		}
+	})
+}